@@ -0,0 +1,363 @@
+package smt
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+func init() {
+	Register(Demo{
+		Name:        "accounts",
+		Category:    "sim",
+		Description: "deposit into an account of every strategy at the same time and compare the balances",
+		Params: simParams(
+			strategyParam,
+			Param{Name: "depositors", Default: 1000, Usage: "goroutines depositing into every account"},
+			Param{Name: "amount", Default: 10, Usage: "amount deposited by every goroutine"},
+		),
+		Run: func(ctx context.Context, w io.Writer, args Args) error {
+			strategies, err := strategyArgs(args)
+			if err != nil {
+				return err
+			}
+			depositors, amount := args.Int("depositors"), args.Int("amount")
+			if depositors <= 0 || amount <= 0 {
+				return usagef("-depositors and -amount must be positive, got %d and %d", depositors, amount)
+			}
+			format, err := formatArgs(args)
+			if err != nil {
+				return err
+			}
+			return CompareAccounts(w, format, strategies, depositors, amount)
+		},
+	})
+}
+
+const compareAccountsInfo = `
+ ACCOUNT STRATEGIES SIMULATION
+ _____________________________
+
++-{ Context }-----------------------------------------------------------------------------------------------+
+|                                                                                                           |
+%s|                                                                                                           |
++-{ Outcomes }----------------------------------------------------------------------------------------------+
+|                                                                                                           |
+%s|                                                                                                           |
++-----------------------------------------------------------------------------------------------------------+
+`
+
+// Account is a bank account that several goroutines may use at once.
+// Every Account owns its balance, which starts at 0, and synchronizes
+// the accesses to it with the strategy it was created with.
+//
+// Withdraw takes amount out of the balance and reports true, unless the
+// balance is lower than amount, in which case it reports false and the
+// balance is left as it is: an account never goes negative, unless its
+// strategy is racy.
+type Account interface {
+	Deposit(amount int)
+	Withdraw(amount int) bool
+	Balance() int
+}
+
+// accountStrategies maps the name of every synchronization strategy
+// to the constructor of its accounts.
+var accountStrategies = []struct {
+	name string
+	new  func() Account
+}{
+	{"unsynchronized", func() Account { return new(racyAccount) }},
+	{"mutex", func() Account { return new(mutexAccount) }},
+	{"rwmutex", func() Account { return new(rwMutexAccount) }},
+	{"atomic", func() Account { return new(atomicAccount) }},
+	{"monitor", func() Account { return newMonitorAccount() }},
+}
+
+// AccountStrategies returns the names of the synchronization strategies.
+func AccountStrategies() []string {
+	var names []string
+	for _, s := range accountStrategies {
+		names = append(names, s.name)
+	}
+	return names
+}
+
+// NewAccount returns an empty account synchronized with strategy.
+func NewAccount(strategy string) (Account, error) {
+	for _, s := range accountStrategies {
+		if s.name == strategy {
+			return s.new(), nil
+		}
+	}
+	return nil, checkStrategy(strategy)
+}
+
+// checkStrategy returns an error if strategy is unknown. It does not
+// create an account, which for a monitor would start its teller.
+func checkStrategy(strategy string) error {
+	for _, s := range accountStrategies {
+		if s.name == strategy {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown strategy %q, want one of %s", strategy, strings.Join(AccountStrategies(), ", "))
+}
+
+var strategyParam = Param{Name: "strategy", Default: "all", Usage: "strategy to run: all or one of " + strings.Join(AccountStrategies(), ", ")}
+
+// strategyArgs returns the strategies chosen by the strategyParam arg.
+func strategyArgs(args Args) ([]string, error) {
+	s := args.String("strategy")
+	if s == "all" {
+		return AccountStrategies(), nil
+	}
+	if err := checkStrategy(s); err != nil {
+		return nil, usageError{err.Error()}
+	}
+	return []string{s}, nil
+}
+
+// CompareAccounts makes depositors goroutines deposit amount into an
+// account of every one of strategies, all the accounts being used at the
+// same time, and compares the resulting balances.
+func CompareAccounts(w io.Writer, format Format, strategies []string, depositors, amount int) error {
+	check := leakCheck()
+	amounts := make([]int, depositors)
+	for i := range amounts {
+		amounts[i] = amount
+	}
+	params := map[string]interface{}{"depositors": depositors, "amount": amount}
+	results := make([]SimulationResult, len(strategies))
+	var wg sync.WaitGroup
+	for i, strategy := range strategies {
+		account, err := NewAccount(strategy)
+		if err != nil {
+			return err
+		}
+		wg.Add(1)
+		go func(i int, strategy string, account Account) {
+			defer wg.Done()
+			start := time.Now()
+			got := deposit(account, amounts...)
+			closeAccount(account)
+			results[i] = newResult("accounts", strategy, params)
+			results[i].Expected, results[i].Observed = depositors*amount, got
+			results[i].Attempts, results[i].Elapsed = 1, time.Since(start)
+		}(i, strategy, account)
+	}
+	wg.Wait()
+	leaks := check()
+
+	if format == JSON {
+		for i := range results {
+			results[i].Goroutines = leaks
+		}
+		return writeResults(w, results...)
+	}
+	var outcome strings.Builder
+	fmt.Fprintf(&outcome, "| %-16s %12s %12s %12s %14s%35s |\n", "strategy", "expected", "observed", "lost", "elapsed", "")
+	for _, r := range results {
+		want, got := r.Expected.(int), r.Observed.(int)
+		fmt.Fprintf(&outcome, "| %-16s %12d %12d %12d %14v%35s |\n", r.Strategy, want, got, want-got, r.Elapsed.Round(time.Microsecond), "")
+	}
+	intro := boxRows(fmt.Sprintf("Every strategy below got its own account, starting at 0, and %d goroutines depositing %d each into it. "+
+		"All the accounts were used at the same time, yet a race in one of them can not leak into the others "+
+		"since every account owns its balance.", depositors, amount))
+	fmt.Fprintf(w, compareAccountsInfo, intro, outcome.String())
+	fmt.Fprintln(w, leaks)
+	return nil
+}
+
+// racyAccount does not synchronize at all: concurrent deposits race.
+type racyAccount struct {
+	balance int
+}
+
+func (a *racyAccount) Deposit(amount int) {
+	// critical section
+	a.balance = a.balance + amount
+}
+
+func (a *racyAccount) Withdraw(amount int) bool {
+	// critical section
+	if a.balance < amount {
+		return false
+	}
+	a.balance = a.balance - amount
+	return true
+}
+
+func (a *racyAccount) Balance() int {
+	return a.balance
+}
+
+// mutexAccount allows a single goroutine at a time to access its balance.
+type mutexAccount struct {
+	mu      sync.Mutex
+	balance int
+}
+
+func (a *mutexAccount) Deposit(amount int) {
+	a.mu.Lock()
+	a.balance = a.balance + amount
+	a.mu.Unlock()
+}
+
+func (a *mutexAccount) Withdraw(amount int) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.balance < amount {
+		return false
+	}
+	a.balance = a.balance - amount
+	return true
+}
+
+func (a *mutexAccount) Balance() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.balance
+}
+
+// rwMutexAccount allows many goroutines at a time to read its balance,
+// but a single one to update it.
+type rwMutexAccount struct {
+	mu      sync.RWMutex
+	balance int
+}
+
+func (a *rwMutexAccount) Deposit(amount int) {
+	a.mu.Lock()
+	a.balance = a.balance + amount
+	a.mu.Unlock()
+}
+
+func (a *rwMutexAccount) Withdraw(amount int) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.balance < amount {
+		return false
+	}
+	a.balance = a.balance - amount
+	return true
+}
+
+func (a *rwMutexAccount) Balance() int {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.balance
+}
+
+// atomicAccount updates its balance with a single atomic operation.
+type atomicAccount struct {
+	balance int64
+}
+
+func (a *atomicAccount) Deposit(amount int) {
+	atomic.AddInt64(&a.balance, int64(amount))
+}
+
+// Withdraw retries until it swaps a balance nobody updated since it read
+// it, a single add could take the balance below 0.
+func (a *atomicAccount) Withdraw(amount int) bool {
+	for {
+		balance := atomic.LoadInt64(&a.balance)
+		if balance < int64(amount) {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&a.balance, balance, balance-int64(amount)) {
+			return true
+		}
+	}
+}
+
+func (a *atomicAccount) Balance() int {
+	return int(atomic.LoadInt64(&a.balance))
+}
+
+// monitorAccount confines its balance to a monitor goroutine, the teller,
+// which the other goroutines ask to query or update it through channels.
+// The teller runs until the account is closed.
+type monitorAccount struct {
+	deposits    chan int        // send amount to deposit
+	withdrawals chan withdrawal // send amount to withdraw, receive success
+	balances    chan int        // receive balance
+	quit        chan struct{}   // closed to stop the teller
+	done        chan struct{}   // closed once the teller stopped
+}
+
+// withdrawal is a request to the teller to withdraw amount, it replies
+// on ok whether there were enough funds.
+type withdrawal struct {
+	amount int
+	ok     chan bool
+}
+
+func newMonitorAccount() *monitorAccount {
+	a := &monitorAccount{
+		deposits:    make(chan int),
+		withdrawals: make(chan withdrawal),
+		balances:    make(chan int),
+		quit:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	go a.teller() // start the monitor
+	return a
+}
+
+func (a *monitorAccount) Deposit(amount int) {
+	a.deposits <- amount
+}
+
+func (a *monitorAccount) Withdraw(amount int) bool {
+	ok := make(chan bool)
+	a.withdrawals <- withdrawal{amount, ok}
+	return <-ok
+}
+
+func (a *monitorAccount) Balance() int {
+	return <-a.balances
+}
+
+// Close stops the teller and waits for it to return. The account
+// must not be used afterwards.
+func (a *monitorAccount) Close() error {
+	close(a.quit)
+	<-a.done
+	return nil
+}
+
+// Monitor goroutine
+func (a *monitorAccount) teller() {
+	defer close(a.done)
+	var balance int
+	for {
+		select {
+		case amount := <-a.deposits:
+			balance += amount
+		case w := <-a.withdrawals:
+			if balance < w.amount {
+				w.ok <- false
+				continue
+			}
+			balance -= w.amount
+			w.ok <- true
+		case a.balances <- balance:
+		case <-a.quit:
+			return
+		}
+	}
+}
+
+// closeAccount releases the resources of account, if any, e.g.
+// the teller goroutine of a monitor account.
+func closeAccount(account Account) {
+	if c, ok := account.(io.Closer); ok {
+		c.Close()
+	}
+}
@@ -0,0 +1,26 @@
+package smt
+
+import (
+	"fmt"
+	"testing"
+)
+
+// BenchmarkAccount benchmarks the accounts of every strategy used by 1, 4
+// and 16 goroutines, half the operations reading the balance, as the bench
+// simulation does by default.
+func BenchmarkAccount(b *testing.B) {
+	for _, strategy := range AccountStrategies() {
+		for _, n := range []int{1, 4, 16} {
+			b.Run(fmt.Sprintf("%s/%d", strategy, n), func(b *testing.B) {
+				account, err := NewAccount(strategy)
+				if err != nil {
+					b.Fatal(err)
+				}
+				defer closeAccount(account)
+				b.ReportAllocs()
+				b.ResetTimer()
+				useAccount(account, n, b.N, 50)
+			})
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package smt
+
+import (
+	"fmt"
+	"net"
+)
+
+// Address is where a server listens or a client connects to.
+type Address struct {
+	Network string // "tcp", "tcp4", "tcp6" or "unix"
+	Host    string // empty means every interface when listening
+	Port    string // "0" lets the system choose one when listening
+	Path    string // socket path, unix network only
+}
+
+// String returns the address as given to net.Listen and net.Dial.
+func (a Address) String() string {
+	if a.Network == "unix" {
+		return a.Path
+	}
+	return net.JoinHostPort(a.Host, a.Port)
+}
+
+// Listen announces on a.
+func (a Address) Listen() (net.Listener, error) {
+	return net.Listen(a.Network, a.String())
+}
+
+// Dial connects to a.
+func (a Address) Dial() (net.Conn, error) {
+	return net.Dial(a.Network, a.String())
+}
+
+// addressParams returns the params describing an Address, host being
+// the default host.
+func addressParams(host string) []Param {
+	return []Param{
+		{Name: "network", Default: "tcp", Usage: "network: tcp, tcp4, tcp6 or unix"},
+		{Name: "host", Default: host, Usage: "host name or IP address, tcp networks only"},
+		{Name: "port", Default: "8000", Usage: "port, 0 picks a free one when listening, tcp networks only"},
+		{Name: "socket", Default: "/tmp/smt.sock", Usage: "socket path, unix network only"},
+	}
+}
+
+// addressArgs returns the Address given by the args of addressParams.
+func addressArgs(args Args) (Address, error) {
+	a := Address{
+		Network: args.String("network"),
+		Host:    args.String("host"),
+		Port:    args.String("port"),
+		Path:    args.String("socket"),
+	}
+	switch a.Network {
+	case "tcp", "tcp4", "tcp6":
+		if a.Port == "" {
+			return a, usagef("-port must not be empty")
+		}
+	case "unix":
+		if a.Path == "" {
+			return a, usagef("-socket must not be empty")
+		}
+	default:
+		return a, usagef("unknown network %q, want tcp, tcp4, tcp6 or unix", a.Network)
+	}
+	return a, nil
+}
+
+// parseAddress returns the address s of the given network, that
+// is the socket path of a unix address or HOST:PORT otherwise.
+func parseAddress(network, s string) (Address, error) {
+	if network == "unix" {
+		return Address{Network: network, Path: s}, nil
+	}
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		return Address{}, fmt.Errorf("bad %s address %q: %v", network, s, err)
+	}
+	return Address{Network: network, Host: host, Port: port}, nil
+}
@@ -0,0 +1,205 @@
+package smt
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	Register(Demo{
+		Name:        "bench",
+		Category:    "sim",
+		Description: "benchmark the account strategies with concurrent readers and depositors",
+		Params: simParams(
+			strategyParam,
+			Param{Name: "goroutines", Default: "1,4,16", Usage: "comma separated numbers of goroutines using every account"},
+			Param{Name: "reads", Default: 50, Usage: "percentage of the operations reading the balance, the rest deposit"},
+			Param{Name: "benchtime", Default: time.Second, Usage: "minimum time every account is driven for, as with go test -benchtime"},
+		),
+		Run: func(ctx context.Context, w io.Writer, args Args) error {
+			strategies, err := strategyArgs(args)
+			if err != nil {
+				return err
+			}
+			var goroutines []int
+			for _, f := range strings.Split(args.String("goroutines"), ",") {
+				n, err := strconv.Atoi(strings.TrimSpace(f))
+				if err != nil || n <= 0 {
+					return usagef("-goroutines must be positive integers, got %q", f)
+				}
+				goroutines = append(goroutines, n)
+			}
+			reads := args.Int("reads")
+			if reads < 0 || reads > 100 {
+				return usagef("-reads must be between 0 and 100, got %d", reads)
+			}
+			benchtime := args.Duration("benchtime")
+			if benchtime <= 0 {
+				return usagef("-benchtime must be positive, got %v", benchtime)
+			}
+			format, err := formatArgs(args)
+			if err != nil {
+				return err
+			}
+			return Benchmark(ctx, w, format, strategies, goroutines, reads, benchtime)
+		},
+	})
+}
+
+const benchmarkInfo = `
+ STRATEGY BENCHMARK
+ __________________
+
++-{ Context }-----------------------------------------------------------------------------------------------+
+|                                                                                                           |
+| Every strategy avoiding the race has a cost. Below, every account was driven by a number of goroutines at |
+| once, every operation being either a read of the balance or a deposit, and timed as a Go benchmark would. |
+| The unsynchronized account is the baseline, wrong but free. go test -bench Account ./src runs the same.   |
+|                                                                                                           |
+%s|                                                                                                           |
+| A mutex lets a single goroutine in at a time, readers included; a RWMutex lets the readers in together;   |
+| atomics need no lock at all; the monitor goroutine pays for a channel round trip per operation.           |
+|                                                                                                           |
++-{ Outcomes }----------------------------------------------------------------------------------------------+
+|                                                                                                           |
+%s|                                                                                                           |
++-----------------------------------------------------------------------------------------------------------+
+`
+
+// benchmark is the outcome of benchmarking a strategy.
+type benchmark struct {
+	strategy   string
+	goroutines int
+	ops        int           // operations
+	elapsed    time.Duration // time they took
+	allocs     uint64        // allocations they made
+	bytes      uint64        // bytes they allocated
+}
+
+func (b benchmark) nsPerOp() int64 {
+	if b.ops <= 0 {
+		return 0
+	}
+	return b.elapsed.Nanoseconds() / int64(b.ops)
+}
+
+func (b benchmark) allocsPerOp() int64 {
+	if b.ops <= 0 {
+		return 0
+	}
+	return int64(b.allocs) / int64(b.ops)
+}
+
+func (b benchmark) bytesPerOp() int64 {
+	if b.ops <= 0 {
+		return 0
+	}
+	return int64(b.bytes) / int64(b.ops)
+}
+
+// opsPerSec returns the operations per second of b.
+func (b benchmark) opsPerSec() float64 {
+	if b.elapsed <= 0 {
+		return 0
+	}
+	return float64(b.ops) / b.elapsed.Seconds()
+}
+
+// Benchmark benchmarks the accounts of every one of strategies used by
+// every number of goroutines, reads percent of the operations reading the
+// balance and the rest depositing into it, every account for benchtime at
+// least. BenchmarkAccount does the same under go test.
+func Benchmark(ctx context.Context, w io.Writer, format Format, strategies []string, goroutines []int, reads int, benchtime time.Duration) error {
+	check := leakCheck()
+	var benchmarks []benchmark
+	for _, strategy := range strategies {
+		for _, n := range goroutines {
+			b, err := measure(ctx, strategy, n, reads, benchtime)
+			if err != nil {
+				return err
+			}
+			benchmarks = append(benchmarks, b)
+		}
+	}
+	leaks := check()
+
+	if format == JSON {
+		var results []SimulationResult
+		for _, b := range benchmarks {
+			r := newResult("bench", b.strategy, map[string]interface{}{"goroutines": b.goroutines, "reads": reads})
+			r.Attempts, r.Elapsed, r.Goroutines = b.ops, b.elapsed, leaks
+			r.Details = map[string]interface{}{
+				"ns_per_op":     b.nsPerOp(),
+				"ops_per_sec":   b.opsPerSec(),
+				"allocs_per_op": b.allocsPerOp(),
+				"bytes_per_op":  b.bytesPerOp(),
+			}
+			results = append(results, r)
+		}
+		return writeResults(w, results...)
+	}
+	var outcome strings.Builder
+	fmt.Fprintf(&outcome, "| %-16s %10s %12s %14s %14s %12s %10s%11s |\n", "strategy", "goroutines", "ops", "ns/op", "ops/sec", "allocs/op", "B/op", "")
+	for _, b := range benchmarks {
+		fmt.Fprintf(&outcome, "| %-16s %10d %12d %14d %14.0f %12d %10d%11s |\n", b.strategy, b.goroutines, b.ops, b.nsPerOp(), b.opsPerSec(), b.allocsPerOp(), b.bytesPerOp(), "")
+	}
+	mix := boxRows(fmt.Sprintf("%d%% of the operations read the balance, the rest deposit. GOMAXPROCS is %d.", reads, runtime.GOMAXPROCS(0)))
+	fmt.Fprintf(w, benchmarkInfo, mix, outcome.String())
+	fmt.Fprintln(w, leaks)
+	return nil
+}
+
+// measure drives a new account of strategy with n goroutines, reads
+// percent of the operations reading the balance, doubling the operations
+// until they take benchtime, as testing.Benchmark does, or ctx is done.
+func measure(ctx context.Context, strategy string, n, reads int, benchtime time.Duration) (benchmark, error) {
+	b := benchmark{strategy: strategy, goroutines: n}
+	for ops := 1; b.elapsed < benchtime; ops *= 2 {
+		if err := ctx.Err(); err != nil {
+			return b, err
+		}
+		account, err := NewAccount(strategy)
+		if err != nil {
+			return b, err
+		}
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		start := time.Now()
+		useAccount(account, n, ops, reads)
+		b.ops, b.elapsed = ops, time.Since(start)
+		runtime.ReadMemStats(&after)
+		b.allocs, b.bytes = after.Mallocs-before.Mallocs, after.TotalAlloc-before.TotalAlloc
+		closeAccount(account)
+	}
+	return b, nil
+}
+
+// useAccount makes n goroutines share ops operations on account, reads
+// percent of them reading the balance and the rest depositing 1.
+func useAccount(account Account, n, ops, reads int) {
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for g := 0; g < n; g++ {
+		mine := ops / n
+		if g < ops%n {
+			mine++
+		}
+		go func(mine int) {
+			defer wg.Done()
+			for i := 0; i < mine; i++ {
+				if i%100 < reads {
+					account.Balance()
+				} else {
+					account.Deposit(1)
+				}
+			}
+		}(mine)
+	}
+	wg.Wait()
+}
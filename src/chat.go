@@ -0,0 +1,245 @@
+package smt
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+func init() {
+	Register(serverDemo("chat", "let every client talk to every other client", Concurrent, []Param{
+		{Name: "idle", Default: 5 * time.Minute, Usage: "disconnect clients silent for this long"},
+	}, func(ctx context.Context, args Args) (Handler, error) {
+		if args.Duration("idle") <= 0 {
+			return nil, usagef("-idle must be positive, got %v", args.Duration("idle"))
+		}
+		return NewChatServer(ctx, args.Duration("idle")), nil
+	}))
+}
+
+const chatHelp = `Commands:
+    /nick NAME   change your name
+    /who         list the connected users
+    /quit        leave the chat
+`
+
+// chatter is a client of the chat. Its name is only read and written by
+// the broadcaster goroutine once the client has entered the chat.
+type chatter struct {
+	name string
+	out  chan string // outgoing messages
+}
+
+type chatMessage struct {
+	from *chatter
+	text string
+}
+
+type chatRename struct {
+	who   *chatter
+	name  string
+	reply chan error
+}
+
+// chat is the state shared by the connections of a chat server: the
+// channels used to talk to the broadcaster goroutine.
+type chat struct {
+	entering chan *chatter
+	leaving  chan *chatter
+	messages chan chatMessage
+	renames  chan chatRename
+	who      chan chan []string
+	idle     time.Duration
+}
+
+// NewChatServer returns a Handler letting the clients of a server talk
+// to each other. A central broadcaster goroutine, running until ctx is
+// done, announces arrivals and departures and sends every message to
+// all the clients. Clients silent for idle are disconnected.
+//
+// Any read-write client, e.g. EchoServerProof, is a chat client.
+func NewChatServer(ctx context.Context, idle time.Duration) Handler {
+	ch := &chat{
+		entering: make(chan *chatter),
+		leaving:  make(chan *chatter),
+		messages: make(chan chatMessage),
+		renames:  make(chan chatRename),
+		who:      make(chan chan []string),
+		idle:     idle,
+	}
+	go ch.broadcaster(ctx)
+	return ch.handle
+}
+
+// broadcaster owns the set of connected clients.
+func (ch *chat) broadcaster(ctx context.Context) {
+	clients := make(map[*chatter]bool)
+	broadcast := func(msg string) {
+		for cli := range clients {
+			select {
+			case cli.out <- msg:
+			default:
+				// the client is not keeping up, skip the message
+			}
+		}
+	}
+	for {
+		select {
+		case cli := <-ch.entering:
+			broadcast(cli.name + " has arrived")
+			clients[cli] = true
+		case cli := <-ch.leaving:
+			delete(clients, cli)
+			broadcast(cli.name + " has left")
+		case msg := <-ch.messages:
+			broadcast(msg.from.name + ": " + msg.text)
+		case r := <-ch.renames:
+			var taken bool
+			for cli := range clients {
+				taken = taken || cli != r.who && cli.name == r.name
+			}
+			if taken {
+				r.reply <- fmt.Errorf("name %s is taken", r.name)
+				continue
+			}
+			if r.who.name == r.name {
+				r.reply <- nil
+				continue
+			}
+			broadcast(r.who.name + " is now known as " + r.name)
+			r.who.name = r.name
+			r.reply <- nil
+		case reply := <-ch.who:
+			var names []string
+			for cli := range clients {
+				names = append(names, cli.name)
+			}
+			sort.Strings(names)
+			reply <- names
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (ch *chat) handle(ctx context.Context, c net.Conn) {
+	defer c.Close()
+	cli := &chatter{name: c.RemoteAddr().String(), out: make(chan string, 16)}
+	done := make(chan struct{})
+	writerDone := make(chan struct{})
+	go func() {
+		chatWriter(c, cli.out, done)
+		close(writerDone)
+	}()
+	go func() {
+		select {
+		case <-ctx.Done():
+			// server shutting down, unblock the scanner
+			c.SetReadDeadline(time.Now())
+		case <-done:
+		}
+	}()
+	defer func() {
+		close(done)
+		<-writerDone
+	}()
+
+	cli.out <- "You are " + cli.name + "\n" + chatHelp
+	select {
+	case ch.entering <- cli:
+	case <-ctx.Done():
+		return
+	}
+
+	input := bufio.NewScanner(c)
+	for {
+		c.SetReadDeadline(time.Now().Add(ch.idle))
+		if ctx.Err() != nil || !input.Scan() || !ch.command(ctx, cli, input.Text()) {
+			break
+		}
+	}
+	switch {
+	case ctx.Err() != nil:
+		cli.out <- "server shutting down, bye"
+		return
+	case errors.Is(input.Err(), os.ErrDeadlineExceeded):
+		cli.out <- fmt.Sprintf("disconnected after %v of silence, bye", ch.idle)
+	}
+
+	select {
+	case ch.leaving <- cli:
+	case <-ctx.Done():
+	}
+}
+
+// command runs a line typed by cli, reporting whether cli is still
+// in the chat.
+func (ch *chat) command(ctx context.Context, cli *chatter, line string) bool {
+	fields := strings.Fields(line)
+	switch {
+	case len(fields) == 0:
+		return true
+	case fields[0] == "/quit":
+		return false
+	case fields[0] == "/nick":
+		if len(fields) != 2 {
+			cli.out <- "usage: /nick NAME"
+			return true
+		}
+		r := chatRename{who: cli, name: fields[1], reply: make(chan error, 1)}
+		select {
+		case ch.renames <- r:
+		case <-ctx.Done():
+			return false
+		}
+		if err := <-r.reply; err != nil {
+			cli.out <- err.Error()
+		}
+		return true
+	case fields[0] == "/who":
+		reply := make(chan []string, 1)
+		select {
+		case ch.who <- reply:
+		case <-ctx.Done():
+			return false
+		}
+		cli.out <- "connected: " + strings.Join(<-reply, ", ")
+		return true
+	case strings.HasPrefix(fields[0], "/"):
+		cli.out <- "unknown command " + fields[0] + "\n" + chatHelp
+		return true
+	}
+
+	select {
+	case ch.messages <- chatMessage{from: cli, text: line}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// chatWriter writes the messages received on out to c until done is
+// closed, then it writes the messages left in out and returns.
+func chatWriter(c net.Conn, out <-chan string, done <-chan struct{}) {
+	for {
+		select {
+		case msg := <-out:
+			fmt.Fprintln(c, msg)
+		case <-done:
+			for {
+				select {
+				case msg := <-out:
+					fmt.Fprintln(c, msg)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
@@ -1,86 +1,42 @@
 package smt
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"os"
-	"strconv"
+	"os/signal"
+	"syscall"
 )
 
+// Run executes the command given by the program arguments, e.g.
+// "smt sim financial-lack --alice 100 --bob 50", and exits with a
+// non-zero status and a message when the command fails. The command
+// is canceled on SIGINT and SIGTERM.
 func Run() {
-	var port string
-	var cway bool
-	flag.Parse()
-
-	switch *CFlag {
-	case 1:
-		cway = false
-	default:
-		cway = true
-	}
-
-	port = "8000"
-	switch *TFlag {
-	case 1:
-		MakeProof(EchoServerProof, port)
-	case 2:
-		MakeProof(ClockServerProof, port)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		// a second signal terminates the program right away
+		<-ctx.Done()
+		stop()
+	}()
+	err := commandTree().execute(ctx, nil, os.Args[1:], os.Stdout, os.Stderr)
+	stop()
+	if errors.Is(err, context.Canceled) {
+		fmt.Fprintf(os.Stderr, "smt: interrupted\n")
+		os.Exit(130)
 	}
-
-	switch *EFlag {
-	case 1:
-		MakeServer(EchoServer, port, cway)
-	case 2:
-		MakeServer(ClockServer, port, cway)
-	case 3:
-		DiskUsage([]string{"."})
-		os.Exit(0)
-	case 4:
-		SpinnerAnimation()
+	switch err.(type) {
+	case nil:
 		os.Exit(0)
+	case usageError:
+		fmt.Fprintf(os.Stderr, "\nsmt: %v\n", err)
+		os.Exit(2)
 	}
-
-	SFlagLen := len(SFlag)
-	if SFlagLen != 0 {
-		switch SFlag[0] {
-		case "2":
-			NoSingleMachineWordSimulation()
-		case "1":
-			if SFlagLen == 3 {
-				alice, err := strconv.Atoi(SFlag[1])
-				if err != nil {
-					os.Exit(1)
-				}
-				bob, err := strconv.Atoi(SFlag[2])
-				if err != nil {
-					os.Exit(1)
-				}
-				FinancialLackSimulation(alice, bob)
-				os.Exit(0)
-			} else {
-				os.Exit(1)
-			}
-		}
-	}
-
-	FFlagLen := len(FFlag)
-	if FFlagLen != 0 {
-		switch FFlag[0] {
-		case "1":
-			if FFlagLen == 3 {
-				alice, err := strconv.Atoi(FFlag[1])
-				if err != nil {
-					os.Exit(1)
-				}
-				bob, err := strconv.Atoi(FFlag[2])
-				if err != nil {
-					os.Exit(1)
-				}
-				AvoidDataRace(alice, bob)
-				os.Exit(0)
-			} else {
-				os.Exit(1)
-			}
-		}
+	if err == flag.ErrHelp {
+		os.Exit(0)
 	}
-	os.Exit(0)
+	fmt.Fprintf(os.Stderr, "smt: %v\n", err)
+	os.Exit(1)
 }
@@ -0,0 +1,550 @@
+package smt
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+func init() {
+	for _, c := range deadlockCases {
+		c := c
+		Register(Demo{
+			Name:        "deadlock-" + c.name,
+			Category:    "sim",
+			Description: c.description,
+			Params: simParams(
+				Param{Name: "rounds", Default: 1000, Usage: "number of " + c.unit + " every goroutine makes"},
+				Param{Name: "stall", Default: 500 * time.Millisecond, Usage: "time without progress the watchdog calls a deadlock"},
+			),
+			Run: func(ctx context.Context, w io.Writer, args Args) error {
+				rounds, stall := args.Int("rounds"), args.Duration("stall")
+				if rounds <= 0 || stall <= 0 {
+					return usagef("-rounds and -stall must be positive")
+				}
+				format, err := formatArgs(args)
+				if err != nil {
+					return err
+				}
+				return Deadlock(ctx, w, format, c, rounds, stall)
+			},
+			Leaky: c.leaky,
+		})
+	}
+}
+
+// deadlockVariant starts goroutines making rounds operations each,
+// counting them in progress. It returns a channel closed once they all
+// returned and, if the goroutines can be freed once deadlocked, a function
+// freeing them.
+type deadlockVariant func(rounds int, progress *int64) (done <-chan struct{}, release func())
+
+// deadlockCase is a way to deadlock, and how not to.
+type deadlockCase struct {
+	name        string
+	description string
+	unit        string      // what the goroutines make rounds of
+	goroutines  int         // number of goroutines a variant starts
+	involved    interface{} // function the deadlocked goroutines are stuck in
+	leaky       bool        // whether the deadlocked goroutines can not be freed
+	deadlock    deadlockVariant
+	fixed       deadlockVariant
+	info        string // formatted with the outcomes of deadlock, then fixed
+}
+
+var deadlockCases = []deadlockCase{
+	{
+		name:        "lock-order",
+		description: "transfer between two accounts locking them in opposite orders",
+		unit:        "transfers",
+		goroutines:  2,
+		involved:    transfer,
+		leaky:       true,
+		deadlock:    lockOrderInversion,
+		fixed:       lockOrdered,
+		info:        lockOrderInfo,
+	},
+	{
+		name:        "relock",
+		description: "deposit into an account locking its mutex twice",
+		unit:        "deposits",
+		goroutines:  1,
+		involved:    (*relockingAccount).setDeposit,
+		leaky:       true,
+		deadlock:    relockDeposits,
+		fixed:       lockOnceDeposits,
+		info:        relockInfo,
+	},
+	{
+		name:        "channel",
+		description: "send deposits on an unbuffered channel nobody receives from yet",
+		unit:        "deposits",
+		goroutines:  1,
+		involved:    unreceivedDeposits,
+		deadlock:    unreceivedDeposits,
+		fixed:       receivedDeposits,
+		info:        unreceivedInfo,
+	},
+}
+
+const lockOrderInfo = `
+ DEADLOCK: LOCK ORDER INVERSION
+ ______________________________
+
++-{ Context }-----------------------------------------------------------------------------------------------+
+|                                                                                                           |
+| A transfer moves money from an account to another, so it locks both accounts: nobody may see the money    |
+| gone from one account and not yet in the other. Alice transfers to Bob while Bob transfers to Alice.      |
+|                                                                                                           |
+| func transfer(from, to *numberedAccount, amount int) {                                                    |
+|   from.mu.Lock()                                                                                          |
+|   defer from.mu.Unlock()                                                                                  |
+|   runtime.Gosched() // let the other transfer lock its first account                                      |
+|   to.mu.Lock()                                                                                            |
+|   defer to.mu.Unlock()                                                                                    |
+|   from.balance -= amount                                                                                  |
+|   to.balance += amount                                                                                    |
+| }                                                                                                         |
+|                                                                                                           |
++-{ Outcome }-----------------------------------------------------------------------------------------------+
+|                                                                                                           |
+%s|                                                                                                           |
++-{ Cycle }-------------------------------------------------------------------------------------------------+
+|                                                                                                           |
+| Alice's transfer locks Alice's account, then waits for Bob's. Bob's transfer locks Bob's account, then    |
+| waits for Alice's. Each waits for a lock the other holds, and neither lets go of the lock it holds until  |
+| it gets the other: a cycle, whose goroutines wait forever.                                                |
+|                                                                                                           |
+|   transfer(alice, bob) --holds--> alice.mu --wanted by--> transfer(bob, alice)                            |
+|   transfer(bob, alice) --holds--> bob.mu   --wanted by--> transfer(alice, bob)                            |
+|                                                                                                           |
+| runtime.Gosched only makes it happen sooner: any transfer may be preempted between its two Lock calls.    |
+|                                                                                                           |
++-{ Fix it }------------------------------------------------------------------------------------------------+
+|                                                                                                           |
+| Lock the accounts in the same order, whatever the direction of the transfer: the one with the lowest      |
+| number first. Whoever locks the first account gets the second one too, eventually, so no cycle can form.  |
+|                                                                                                           |
+| func orderedTransfer(from, to *numberedAccount, amount int) {                                             |
+|   first, second := from, to                                                                               |
+|   if second.number < first.number {                                                                       |
+|     first, second = second, first                                                                         |
+|   }                                                                                                       |
+|   first.mu.Lock()                                                                                         |
+|   defer first.mu.Unlock()                                                                                 |
+|   runtime.Gosched()                                                                                       |
+|   second.mu.Lock()                                                                                        |
+|   defer second.mu.Unlock()                                                                                |
+|   from.balance -= amount                                                                                  |
+|   to.balance += amount                                                                                    |
+| }                                                                                                         |
+|                                                                                                           |
+%s|                                                                                                           |
++-----------------------------------------------------------------------------------------------------------+
+`
+
+const relockInfo = `
+ DEADLOCK: LOCKING A MUTEX TWICE
+ _______________________________
+
++-{ Context }-----------------------------------------------------------------------------------------------+
+|                                                                                                           |
+| Deposit locks the account to count the deposit, then calls setDeposit to update the balance, which        |
+| locks the account too, as every method touching the balance should.                                       |
+|                                                                                                           |
+| func (a *relockingAccount) Deposit(amount int) {                                                          |
+|   a.mu.Lock()                                                                                             |
+|   defer a.mu.Unlock()                                                                                     |
+|   a.deposits++                                                                                            |
+|   a.setDeposit(amount)                                                                                    |
+| }                                                                                                         |
+|                                                                                                           |
+| func (a *relockingAccount) setDeposit(amount int) {                                                       |
+|   a.mu.Lock() // already locked by Deposit                                                                |
+|   defer a.mu.Unlock()                                                                                     |
+|   a.balance += amount                                                                                     |
+| }                                                                                                         |
+|                                                                                                           |
++-{ Outcome }-----------------------------------------------------------------------------------------------+
+|                                                                                                           |
+%s|                                                                                                           |
++-{ Cycle }-------------------------------------------------------------------------------------------------+
+|                                                                                                           |
+| A sync.Mutex is not reentrant: it does not know which goroutine holds it, so locking it again from the    |
+| goroutine holding it waits like any other goroutine would, for the holder to unlock it. The holder being  |
+| the goroutine waiting, the cycle is made of a single goroutine:                                           |
+|                                                                                                           |
+|   Deposit --holds--> a.mu --wanted by--> setDeposit, called by Deposit                                    |
+|                                                                                                           |
++-{ Fix it }------------------------------------------------------------------------------------------------+
+|                                                                                                           |
+| Lock in the exported methods only, and have the unexported helpers they call expect the lock to be        |
+| held, saying so in their doc comment.                                                                     |
+|                                                                                                           |
+| func (a *lockOnceAccount) Deposit(amount int) {                                                           |
+|   a.mu.Lock()                                                                                             |
+|   defer a.mu.Unlock()                                                                                     |
+|   a.deposits++                                                                                            |
+|   a.setDeposit(amount)                                                                                    |
+| }                                                                                                         |
+|                                                                                                           |
+| // setDeposit adds amount to the balance, a.mu must be held.                                              |
+| func (a *lockOnceAccount) setDeposit(amount int) {                                                        |
+|   a.balance += amount                                                                                     |
+| }                                                                                                         |
+|                                                                                                           |
+%s|                                                                                                           |
++-----------------------------------------------------------------------------------------------------------+
+`
+
+const unreceivedInfo = `
+ DEADLOCK: UNBUFFERED SEND WITHOUT A RECEIVER
+ ____________________________________________
+
++-{ Context }-----------------------------------------------------------------------------------------------+
+|                                                                                                           |
+| The deposits are sent on a channel, then received from it and made, one at a time, by the same            |
+| goroutine.                                                                                                |
+|                                                                                                           |
+| amounts := make(chan int)                                                                                 |
+| go func() {                                                                                               |
+|   for i := 0; i < rounds; i++ {                                                                           |
+|     amounts <- 1 // waits for a receiver                                                                  |
+|   }                                                                                                       |
+|   close(amounts)                                                                                          |
+|   for amount := range amounts {                                                                           |
+|     account.Deposit(amount)                                                                               |
+|   }                                                                                                       |
+| }()                                                                                                       |
+|                                                                                                           |
++-{ Outcome }-----------------------------------------------------------------------------------------------+
+|                                                                                                           |
+%s|                                                                                                           |
++-{ Cycle }-------------------------------------------------------------------------------------------------+
+|                                                                                                           |
+| A send on an unbuffered channel waits for a receiver to take the value. The only receiver is the          |
+| goroutine sending, which cannot receive before its send completes:                                        |
+|                                                                                                           |
+|   send on amounts --waits for--> receive from amounts --comes after--> send on amounts                    |
+|                                                                                                           |
+| Had every goroutine been blocked, the runtime would have stopped the program with "fatal error: all       |
+| goroutines are asleep - deadlock!". Here the other goroutines keep going, so only a watchdog notices.     |
+|                                                                                                           |
++-{ Fix it }------------------------------------------------------------------------------------------------+
+|                                                                                                           |
+| Receive in another goroutine, started before sending. A buffer would only delay the deadlock until it     |
+| is full, unless it holds every value sent.                                                                |
+|                                                                                                           |
+| amounts := make(chan int)                                                                                 |
+| go func() {                                                                                               |
+|   for amount := range amounts {                                                                           |
+|     account.Deposit(amount)                                                                               |
+|   }                                                                                                       |
+| }()                                                                                                       |
+| go func() {                                                                                               |
+|   for i := 0; i < rounds; i++ {                                                                           |
+|     amounts <- 1                                                                                          |
+|   }                                                                                                       |
+|   close(amounts)                                                                                          |
+| }()                                                                                                       |
+|                                                                                                           |
+%s|                                                                                                           |
++-----------------------------------------------------------------------------------------------------------+
+`
+
+// numberedAccount is a mutexAccount with a number, giving the accounts an
+// order to be locked in.
+type numberedAccount struct {
+	number int
+	mutexAccount
+}
+
+// transfer moves amount from one account to another, locking from first.
+func transfer(from, to *numberedAccount, amount int) {
+	from.mu.Lock()
+	defer from.mu.Unlock()
+	runtime.Gosched() // let the other transfer lock its first account
+	to.mu.Lock()
+	defer to.mu.Unlock()
+	from.balance -= amount
+	to.balance += amount
+}
+
+// orderedTransfer moves amount from one account to another, locking the
+// account with the lowest number first, whatever the direction.
+func orderedTransfer(from, to *numberedAccount, amount int) {
+	first, second := from, to
+	if second.number < first.number {
+		first, second = second, first
+	}
+	first.mu.Lock()
+	defer first.mu.Unlock()
+	runtime.Gosched()
+	second.mu.Lock()
+	defer second.mu.Unlock()
+	from.balance -= amount
+	to.balance += amount
+}
+
+// transfers makes Alice and Bob transfer to each other rounds times each
+// with transfer.
+func transfers(rounds int, progress *int64, transfer func(from, to *numberedAccount, amount int)) <-chan struct{} {
+	alice, bob := &numberedAccount{number: 1}, &numberedAccount{number: 2}
+	alice.balance, bob.balance = rounds, rounds
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(2)
+	for _, pair := range [][2]*numberedAccount{{alice, bob}, {bob, alice}} {
+		go func(from, to *numberedAccount) {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				transfer(from, to, 1)
+				atomic.AddInt64(progress, 1)
+			}
+		}(pair[0], pair[1])
+	}
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	return done
+}
+
+func lockOrderInversion(rounds int, progress *int64) (<-chan struct{}, func()) {
+	return transfers(rounds, progress, transfer), nil
+}
+
+func lockOrdered(rounds int, progress *int64) (<-chan struct{}, func()) {
+	return transfers(rounds, progress, orderedTransfer), nil
+}
+
+// relockingAccount locks its mutex in Deposit, then again in setDeposit.
+type relockingAccount struct {
+	mu       sync.Mutex
+	deposits int
+	balance  int
+}
+
+func (a *relockingAccount) Deposit(amount int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.deposits++
+	a.setDeposit(amount)
+}
+
+func (a *relockingAccount) setDeposit(amount int) {
+	a.mu.Lock() // already locked by Deposit
+	defer a.mu.Unlock()
+	a.balance += amount
+}
+
+// lockOnceAccount locks its mutex in Deposit only.
+type lockOnceAccount struct {
+	mu       sync.Mutex
+	deposits int
+	balance  int
+}
+
+func (a *lockOnceAccount) Deposit(amount int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.deposits++
+	a.setDeposit(amount)
+}
+
+// setDeposit adds amount to the balance, a.mu must be held.
+func (a *lockOnceAccount) setDeposit(amount int) {
+	a.balance += amount
+}
+
+// deposits deposits 1 into account rounds times.
+func deposits(rounds int, progress *int64, account interface{ Deposit(amount int) }) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < rounds; i++ {
+			account.Deposit(1)
+			atomic.AddInt64(progress, 1)
+		}
+	}()
+	return done
+}
+
+func relockDeposits(rounds int, progress *int64) (<-chan struct{}, func()) {
+	return deposits(rounds, progress, new(relockingAccount)), nil
+}
+
+func lockOnceDeposits(rounds int, progress *int64) (<-chan struct{}, func()) {
+	return deposits(rounds, progress, new(lockOnceAccount)), nil
+}
+
+// unreceivedDeposits sends its deposits on an unbuffered channel before
+// receiving them, in the same goroutine.
+func unreceivedDeposits(rounds int, progress *int64) (<-chan struct{}, func()) {
+	account := new(mutexAccount)
+	amounts := make(chan int)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < rounds; i++ {
+			amounts <- 1 // waits for a receiver
+		}
+		close(amounts)
+		for amount := range amounts {
+			account.Deposit(amount)
+			atomic.AddInt64(progress, 1)
+		}
+	}()
+	release := func() {
+		received := amounts
+		for {
+			select {
+			case _, ok := <-received:
+				if !ok {
+					received = nil
+				}
+			case <-done:
+				return
+			}
+		}
+	}
+	return done, release
+}
+
+// receivedDeposits receives its deposits in a goroutine started before
+// sending them.
+func receivedDeposits(rounds int, progress *int64) (<-chan struct{}, func()) {
+	account := new(mutexAccount)
+	amounts := make(chan int)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for amount := range amounts {
+			account.Deposit(amount)
+			atomic.AddInt64(progress, 1)
+		}
+	}()
+	go func() {
+		for i := 0; i < rounds; i++ {
+			amounts <- 1
+		}
+		close(amounts)
+	}()
+	return done, nil
+}
+
+// deadlockRun is the outcome of running a variant of a deadlock case.
+type deadlockRun struct {
+	progress   int64
+	deadlocked time.Duration // when the watchdog found the deadlock, 0 if none
+	released   bool          // whether the deadlocked goroutines were freed
+	stacks     []string      // stacks of the goroutines the variant started, once deadlocked
+	elapsed    time.Duration
+}
+
+// runDeadlockVariant runs variant until its goroutines return, the
+// watchdog finds them deadlocked or ctx is done. The stacks of the
+// deadlocked goroutines are those in function involved.
+func runDeadlockVariant(ctx context.Context, variant deadlockVariant, involved interface{}, rounds int, stall time.Duration) deadlockRun {
+	var r deadlockRun
+	start := time.Now()
+	done, release := variant(rounds, &r.progress)
+	watched, stopWatching := context.WithCancel(ctx)
+	defer stopWatching()
+	stalled := watchdog(watched, func() int64 { return atomic.LoadInt64(&r.progress) }, stall)
+	select {
+	case <-done:
+	case <-stalled:
+		r.deadlocked = time.Since(start)
+		r.stacks = goroutineStacks(funcName(involved))
+		if release != nil {
+			release()
+			r.released = true
+		}
+	case <-ctx.Done():
+	}
+	r.elapsed = time.Since(start)
+	r.progress = atomic.LoadInt64(&r.progress)
+	return r
+}
+
+// Deadlock runs the deadlocking variant of c, its goroutines making rounds
+// operations each, under a watchdog calling a deadlock once they made no
+// progress for stall, showing their stacks if they deadlocked. It then
+// runs the fixed variant of c the same way.
+func Deadlock(ctx context.Context, w io.Writer, format Format, c deadlockCase, rounds int, stall time.Duration) error {
+	check := leakCheck()
+	deadlocked := runDeadlockVariant(ctx, c.deadlock, c.involved, rounds, stall)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	fixed := runDeadlockVariant(ctx, c.fixed, c.involved, rounds, stall)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	leaks := check()
+	want := rounds * c.goroutines
+
+	if format == JSON {
+		var results []SimulationResult
+		for _, v := range []struct {
+			name string
+			run  deadlockRun
+		}{{"deadlock", deadlocked}, {"fixed", fixed}} {
+			r := newResult("deadlock-"+c.name, v.name, map[string]interface{}{"rounds": rounds, "stall_ns": stall})
+			r.Expected, r.Observed, r.Attempts, r.Elapsed, r.Goroutines = want, v.run.progress, 1, v.run.elapsed, leaks
+			r.Details = map[string]interface{}{
+				"deadlocked":    v.run.deadlocked > 0,
+				"deadlocked_ns": v.run.deadlocked,
+				"released":      v.run.released,
+				"stacks":        v.run.stacks,
+			}
+			results = append(results, r)
+		}
+		return writeResults(w, results...)
+	}
+	fmt.Fprintf(w, c.info, deadlockOutcome(deadlocked, c.unit, want, stall), deadlockOutcome(fixed, c.unit, want, stall))
+	fmt.Fprintln(w, leaks)
+	return nil
+}
+
+// deadlockOutcome returns the box rows telling how run went.
+func deadlockOutcome(run deadlockRun, unit string, want int, stall time.Duration) string {
+	var b strings.Builder
+	if run.deadlocked == 0 {
+		fmt.Fprintf(&b, "| %-105s |\n", fmt.Sprintf("No deadlock: %d of %d %s made in %v.", run.progress, want, unit, run.elapsed.Round(time.Microsecond)))
+		return b.String()
+	}
+	fmt.Fprintf(&b, "| %-105s |\n", fmt.Sprintf("DEADLOCK: the watchdog saw no progress for %v, after %d of %d %s. The goroutines involved:",
+		stall, run.progress, want, unit))
+	for _, stack := range run.stacks {
+		fmt.Fprintf(&b, "| %-105s |\n", "")
+		for _, line := range strings.Split(stack, "\n") {
+			fmt.Fprintf(&b, "| %-105s |\n", "  "+line)
+		}
+	}
+	fmt.Fprintf(&b, "| %-105s |\n", "")
+	if run.released {
+		fmt.Fprintf(&b, "| %-105s |\n", "Once the stacks were taken, receiving from the channel freed the goroutines above.")
+	} else {
+		fmt.Fprintf(&b, "| %-105s |\n", "Nothing interrupts a goroutine waiting for a mutex: the goroutines above leak until the program exits.")
+	}
+	return b.String()
+}
+
+// goroutineStacks returns the stacks of the goroutines running function
+// involved, given by its full name, or a function literal of it.
+func goroutineStacks(involved string) []string {
+	var stacks []string
+	for _, g := range goroutines() {
+		if g.runs(involved) {
+			stacks = append(stacks, g.String())
+		}
+	}
+	return stacks
+}
@@ -7,6 +7,7 @@ package smt
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -15,11 +16,129 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 )
 
+func init() {
+	Register(Demo{
+		Name:        "du",
+		Category:    "example",
+		Description: "compute the disk usage of the files in PATHs, the current directory by default",
+		Args:        "[PATH...]",
+		Run: func(ctx context.Context, w io.Writer, args Args) error {
+			return DiskUsage(ctx, w, args.Rest())
+		},
+	})
+	Register(Demo{
+		Name:        "spinner",
+		Category:    "example",
+		Description: "show a spinner while computing the 45th Fibonacci number",
+		Run: func(ctx context.Context, w io.Writer, args Args) error {
+			return SpinnerAnimation(ctx, w)
+		},
+	})
+	Register(serverDemo("echo", "echo every line back, loud, moderate and quiet", Sequential, nil,
+		func(context.Context, Args) (Handler, error) {
+			return EchoServer, nil
+		}))
+	Register(serverDemo("clock", "write the time of a timezone periodically", Sequential,
+		[]Param{
+			{Name: "tz", Default: "Local", Usage: "timezone of the time written, e.g. America/New_York"},
+			{Name: "tick", Default: 1 * time.Second, Usage: "interval between two writes"},
+		},
+		func(_ context.Context, args Args) (Handler, error) {
+			loc, err := time.LoadLocation(args.String("tz"))
+			if err != nil {
+				return nil, usageError{err.Error()}
+			}
+			if args.Duration("tick") <= 0 {
+				return nil, usagef("-tick must be positive, got %v", args.Duration("tick"))
+			}
+			return NewClockServer(loc, args.Duration("tick")), nil
+		}))
+}
+
+// serverDemo returns the demo serving the handler built by handler from
+// the demo args, params being the flags of the handler and mode the mode
+// served in by default. The context given to handler is done once the
+// server stops, or fails to start.
+func serverDemo(name, description string, mode ServerMode, params []Param, handler func(context.Context, Args) (Handler, error)) Demo {
+	return Demo{
+		Name:        name,
+		Category:    "serve",
+		Description: description,
+		Params: append(append(addressParams(""), []Param{
+			{Name: "mode", Default: mode.String(), Usage: "how to handle connections: sequential, concurrent or pool"},
+			{Name: "concurrent", Default: false, Usage: "short for -mode concurrent"},
+			{Name: "workers", Default: 4, Usage: "number of handler goroutines in pool mode"},
+			{Name: "queue", Default: 8, Usage: "number of connections waiting for a worker in pool mode"},
+			{Name: "policy", Default: "block", Usage: "what to do when the pool queue is full: block, reject or drop"},
+			{Name: "drain", Default: 5 * time.Second, Usage: "how long to wait for open connections on shutdown"},
+		}...), params...),
+		Run: func(ctx context.Context, w io.Writer, args Args) error {
+			cfg, err := serverConfig(args)
+			if err != nil {
+				return err
+			}
+			// stops what the handler started, e.g. the chat broadcaster,
+			// even if the server fails to listen
+			handlerCtx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			fn, err := handler(handlerCtx, args)
+			if err != nil {
+				return err
+			}
+			cfg.Listening = func(addr net.Addr) {
+				fmt.Fprintf(w, "%s server listening on %s %s\n", name, addr.Network(), addr)
+			}
+			report, err := MakeServer(ctx, fn, cfg)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "%s server stopped: %d connections served, %d rejected, %d dropped, %d force-closed\n",
+				name, report.Served, report.Rejected, report.Dropped, report.ForceClosed)
+			return nil
+		},
+	}
+}
+
+// serverConfig returns the configuration given by the server demo args.
+func serverConfig(args Args) (ServerConfig, error) {
+	addr, err := addressArgs(args)
+	if err != nil {
+		return ServerConfig{}, err
+	}
+	cfg := ServerConfig{
+		Addr:         addr,
+		Workers:      args.Int("workers"),
+		QueueDepth:   args.Int("queue"),
+		DrainTimeout: args.Duration("drain"),
+	}
+	if cfg.Mode, err = ParseServerMode(args.String("mode")); err != nil {
+		return cfg, usageError{err.Error()}
+	}
+	if args.Bool("concurrent") {
+		if cfg.Mode == Pool {
+			return cfg, usagef("-concurrent conflicts with -mode pool")
+		}
+		cfg.Mode = Concurrent
+	}
+	if cfg.QueuePolicy, err = ParseQueuePolicy(args.String("policy")); err != nil {
+		return cfg, usageError{err.Error()}
+	}
+	if cfg.Mode == Pool && cfg.Workers <= 0 {
+		return cfg, usagef("-workers must be positive, got %d", cfg.Workers)
+	}
+	if cfg.QueueDepth < 0 {
+		return cfg, usagef("-queue must not be negative, got %d", cfg.QueueDepth)
+	}
+	return cfg, nil
+}
+
 // DiskUsage computes the disk usage of the files in a directory.
-func DiskUsage(roots []string) {
+// It stops walking the directories once ctx is done.
+func DiskUsage(ctx context.Context, w io.Writer, roots []string) error {
 	if len(roots) == 0 {
 		roots = []string{"."}
 	}
@@ -27,7 +146,7 @@ func DiskUsage(roots []string) {
 	fileSizes := make(chan int64)
 	go func() {
 		for _, root := range roots {
-			walkDir(root, fileSizes)
+			walkDir(ctx, root, fileSizes)
 		}
 		close(fileSizes)
 	}()
@@ -37,16 +156,23 @@ func DiskUsage(roots []string) {
 		nfiles++
 		nbytes += size
 	}
-	printDiskUsage(nfiles, nbytes)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	printDiskUsage(w, nfiles, nbytes)
+	return nil
 }
 
 // walkDir recursively walks the file tree rooted at dir
 // and sends the size of each found file on fileSizes.
-func walkDir(dir string, fileSizes chan<- int64) {
+func walkDir(ctx context.Context, dir string, fileSizes chan<- int64) {
 	for _, entry := range dirents(dir) {
+		if ctx.Err() != nil {
+			return
+		}
 		if entry.IsDir() {
 			subdir := filepath.Join(dir, entry.Name())
-			walkDir(subdir, fileSizes)
+			walkDir(ctx, subdir, fileSizes)
 		} else {
 			fileSizes <- entry.Size()
 		}
@@ -63,91 +189,155 @@ func dirents(dir string) []os.FileInfo {
 	return entries
 }
 
-func printDiskUsage(nfiles, nbytes int64) {
-	fmt.Printf("%d files %.1f GB\n", nfiles, float64(nbytes)/1e9)
+func printDiskUsage(w io.Writer, nfiles, nbytes int64) {
+	fmt.Fprintf(w, "%d files %.1f GB\n", nfiles, float64(nbytes)/1e9)
 }
 
 // SpinnerAnimation computes the 45th Fibonacci number. Since it
 // uses the terribly inefficient recursive algorithm, it runs for
 // an appreciable time, during which it provide the user with a
 // visual indication that the program is still running by displaying
-// an animated textual "spinner".
-func SpinnerAnimation() {
-	go spinner(100 * time.Millisecond)
+// an animated textual "spinner". It gives up once ctx is done.
+func SpinnerAnimation(ctx context.Context, w io.Writer) error {
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		spinner(w, 100*time.Millisecond, stop)
+	}()
+	stopSpinner := func() {
+		close(stop)
+		<-stopped
+	}
 	const n = 45
-	fibN := fibonacci(n)
-	fmt.Printf("\rFibonacci(%d) = %d\n", n, fibN)
+	fibN := make(chan int, 1)
+	go func() {
+		fibN <- fibonacci(ctx, n)
+	}()
+	select {
+	case f := <-fibN:
+		stopSpinner()
+		fmt.Fprintf(w, "\rFibonacci(%d) = %d\n", n, f)
+		return nil
+	case <-ctx.Done():
+		stopSpinner()
+		fmt.Fprintf(w, "\r")
+		return ctx.Err()
+	}
 }
 
-func spinner(delay time.Duration) {
+// spinner animates a spinner on w, a frame every delay, until stop is
+// closed.
+func spinner(w io.Writer, delay time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(delay)
+	defer ticker.Stop()
 	for {
 		for _, r := range `-\|/` {
-			fmt.Printf("\r%c", r)
-			time.Sleep(delay)
+			fmt.Fprintf(w, "\r%c", r)
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
 		}
 	}
 }
 
-func fibonacci(n int) int {
+// fibonacci returns the nth Fibonacci number, or garbage once ctx is
+// done: it then gives up, checking ctx for the largest numbers only so as
+// not to slow down the computation.
+func fibonacci(ctx context.Context, n int) int {
 	if n < 2 {
 		return n
 	}
-	return fibonacci(n-1) + fibonacci(n-2)
+	if n > 25 && ctx.Err() != nil {
+		return 0
+	}
+	return fibonacci(ctx, n-1) + fibonacci(ctx, n-2)
 }
 
-// MakeServer make easy to create this package's servers, e.g, ClockServer
-// or EchoServer. If cway is true the server will be executed in a concurrent
-// fashion, otherwise the server will be executed in a sequential fashion.
-func MakeServer(fn func(net.Conn), port string, cway bool) error {
-	listener, err := net.Listen("tcp", "0.0.0.0:"+port)
-	if err != nil {
-		return err
-	}
-	for {
-		conn, err := listener.Accept()
-		if err != nil {
-			// connection aborted
-			log.Print(err)
-			continue
-		}
-		if cway {
-			go fn(conn)
-		} else {
-			fn(conn)
-		}
+// ClockServer is a TCP server that periodically writes the time.
+func ClockServer(ctx context.Context, c net.Conn) {
+	clock(ctx, c, time.Local, 1*time.Second)
+}
+
+// NewClockServer returns a ClockServer writing the time in loc
+// every tick.
+func NewClockServer(loc *time.Location, tick time.Duration) Handler {
+	return func(ctx context.Context, c net.Conn) {
+		clock(ctx, c, loc, tick)
 	}
-	return nil
 }
 
-// ClockServer is a TCP server that periodically writes the time.
-func ClockServer(c net.Conn) {
+func clock(ctx context.Context, c net.Conn, loc *time.Location, tick time.Duration) {
 	defer c.Close()
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
 	for {
-		_, err := io.WriteString(c, time.Now().Format("15:04:05\n"))
+		_, err := io.WriteString(c, time.Now().In(loc).Format("15:04:05\n"))
 		if err != nil {
 			// client disconnected
 			return
 		}
-		time.Sleep(1 * time.Second)
+		select {
+		case <-ctx.Done():
+			// server shutting down
+			return
+		case <-ticker.C:
+		}
 	}
 }
 
 // EchoServe Simulate the reverberations of a real echo, with
 // the response loud at first ("WTF!"), then moderate ("Wtf!")
 // after a delay, then quiet ("wft!") before fading to nothing.
-func EchoServer(c net.Conn) {
+// It stops reading once ctx is done or the client closes its
+// side of the connection, and hangs up once every echo faded.
+func EchoServer(ctx context.Context, c net.Conn) {
 	defer c.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			// server shutting down, unblock the scanner
+			c.SetReadDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	var wg sync.WaitGroup
 	input := bufio.NewScanner(c)
 	for input.Scan() {
-		go echo(c, input.Text(), 1*time.Second)
+		wg.Add(1)
+		go func(shout string) {
+			defer wg.Done()
+			if err := echo(ctx, c, shout, 1*time.Second); err != nil && ctx.Err() == nil {
+				log.Printf("echo: %v", err)
+			}
+		}(input.Text())
 	}
+	wg.Wait()
 }
 
-func echo(c net.Conn, shout string, delay time.Duration) {
+// echo writes the reverberations of shout to c, giving up at
+// the first write error, e.g. when the client went away, or once
+// ctx is done: the server may close c by force by then, and the
+// reverberations must not outlive it.
+func echo(ctx context.Context, c net.Conn, shout string, delay time.Duration) error {
 	shout = strings.ToLower(shout)
-	fmt.Fprintln(c, "\t>> ", strings.ToUpper(shout))
-	time.Sleep(delay)
-	fmt.Fprintln(c, "\t>> ", strings.Title(shout))
-	time.Sleep(delay)
-	fmt.Fprintln(c, "\t>> ", shout)
+	for i, s := range []string{strings.ToUpper(shout), strings.Title(shout), shout} {
+		if i > 0 {
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			}
+		}
+		if _, err := fmt.Fprintln(c, "\t>> ", s); err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,150 @@
+package smt
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	Register(Demo{
+		Name:        "race-frequency",
+		Category:    "sim",
+		Description: "deposit Alice's and Bob's amounts concurrently many times and tell how often a deposit is lost",
+		Params: simParams(append(depositParams,
+			Param{Name: "trials", Default: 100000, Usage: "number of trials, 0 means until the budget is spent"},
+			Param{Name: "budget", Default: time.Duration(0), Usage: "stop after this long, 0 means after the trials"},
+		)...),
+		Run: func(ctx context.Context, w io.Writer, args Args) error {
+			alice, bob, err := depositArgs(args)
+			if err != nil {
+				return err
+			}
+			trials, budget := args.Int("trials"), args.Duration("budget")
+			if trials < 0 || budget < 0 || trials == 0 && budget == 0 {
+				return usagef("-trials and -budget must not be negative, and at least one of them positive")
+			}
+			format, err := formatArgs(args)
+			if err != nil {
+				return err
+			}
+			return RaceFrequency(ctx, w, format, alice, bob, trials, budget)
+		},
+	})
+}
+
+const raceFrequencyInfo = `
+ RACE FREQUENCY EXPERIMENT
+ _________________________
+
++-{ Context }-----------------------------------------------------------------------------------------------+
+|                                                                                                           |
+| A single lost deposit proves there is a race, but says nothing about how often it happens. Here Alice and |
+| Bob deposited into a new unsynchronized account trial after trial, and every final balance was recorded.  |
+|                                                                                                           |
+%s|                                                                                                           |
+| How often the race shows up depends on how many goroutines really run at once, GOMAXPROCS, and on         |
+| what else the machine is doing. A race seen once in a million trials is still a bug.                      |
+|                                                                                                           |
++-{ Histogram }---------------------------------------------------------------------------------------------+
+|                                                                                                           |
+%s|                                                                                                           |
+%s|                                                                                                           |
++-----------------------------------------------------------------------------------------------------------+
+`
+
+// RaceFrequency deposits alice and bob concurrently into a new
+// unsynchronized account, trials times or until budget is spent, whichever
+// comes first, a zero value meaning no limit. It reports how often every
+// final balance was observed and how likely a lost deposit is.
+func RaceFrequency(ctx context.Context, w io.Writer, format Format, alice, bob, trials int, budget time.Duration) error {
+	check := leakCheck()
+	f := raceFrequency(ctx, alice, bob, trials, budget)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	leaks := check()
+
+	if format == JSON {
+		histogram := make(map[string]int)
+		for balance, n := range f.balances {
+			histogram[strconv.Itoa(balance)] = n
+		}
+		r := newResult("race-frequency", "unsynchronized", map[string]interface{}{"alice": alice, "bob": bob, "trials": trials, "budget_ns": budget})
+		r.Expected, r.Observed, r.Attempts, r.Elapsed, r.Goroutines = f.want, histogram, f.trials, f.elapsed, leaks
+		r.Details = map[string]interface{}{"races": f.races(), "race_probability": f.probability()}
+		return writeResults(w, r)
+	}
+	setup := boxRows(fmt.Sprintf("Alice deposited %d and Bob %d, so every balance other than %d is a lost deposit. There were %d trials, "+
+		"taking %v, with GOMAXPROCS set to %d.", alice, bob, f.want, f.trials, f.elapsed.Round(time.Millisecond), runtime.GOMAXPROCS(0)))
+	probability := boxRows(fmt.Sprintf("Race probability: %.6f (%d of %d trials lost a deposit)", f.probability(), f.races(), f.trials))
+	fmt.Fprintf(w, raceFrequencyInfo, setup, f.histogram(), probability)
+	fmt.Fprintln(w, leaks)
+	return nil
+}
+
+// frequency is the outcome of a race frequency experiment.
+type frequency struct {
+	want     int         // balance without races
+	trials   int         // trials run
+	balances map[int]int // final balance -> trials ending with it
+	elapsed  time.Duration
+}
+
+func raceFrequency(ctx context.Context, a, b, trials int, budget time.Duration) frequency {
+	f := frequency{want: a + b, balances: make(map[int]int)}
+	start := time.Now()
+	for (trials == 0 || f.trials < trials) && (budget == 0 || time.Since(start) < budget) && ctx.Err() == nil {
+		f.balances[deposit(new(racyAccount), a, b)]++
+		f.trials++
+	}
+	f.elapsed = time.Since(start)
+	return f
+}
+
+// races returns the number of trials that lost a deposit.
+func (f frequency) races() int {
+	return f.trials - f.balances[f.want]
+}
+
+// probability returns the observed probability of losing a deposit.
+func (f frequency) probability() float64 {
+	if f.trials == 0 {
+		return 0
+	}
+	return float64(f.races()) / float64(f.trials)
+}
+
+// histogram returns the box rows charting how often every balance was
+// observed, the bars being scaled to the most frequent one.
+func (f frequency) histogram() string {
+	const width = 60
+	var balances []int
+	most := 0
+	for balance, n := range f.balances {
+		balances = append(balances, balance)
+		if n > most {
+			most = n
+		}
+	}
+	sort.Ints(balances)
+	var b strings.Builder
+	for _, balance := range balances {
+		n := f.balances[balance]
+		bar := strings.Repeat("#", n*width/most)
+		if bar == "" {
+			bar = "." // too rare to be drawn to scale, yet observed
+		}
+		note := ""
+		if balance != f.want {
+			note = "lost"
+		}
+		fmt.Fprintf(&b, "| %8d %-4s %-*s %10d %8.4f%% %9s |\n", balance, note, width, bar, n, 100*float64(n)/float64(f.trials), "")
+	}
+	return b.String()
+}
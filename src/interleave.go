@@ -0,0 +1,247 @@
+package smt
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	Register(Demo{
+		Name:        "interleavings",
+		Category:    "sim",
+		Description: "enumerate every interleaving of concurrent deposits and show the ones losing a deposit",
+		Params: simParams(
+			Param{Name: "amounts", Default: "100,50", Usage: "comma separated amounts deposited, one per depositor, at most " + strconv.Itoa(len(depositorNames))},
+			Param{Name: "show", Default: 100, Usage: "most schedules listed, 0 means all of them"},
+			Param{Name: "lost-only", Default: false, Usage: "list only the schedules losing a deposit"},
+		),
+		Run: func(ctx context.Context, w io.Writer, args Args) error {
+			amounts, err := amountsArgs(args)
+			if err != nil {
+				return err
+			}
+			show := args.Int("show")
+			if show < 0 {
+				return usagef("-show must not be negative, got %d", show)
+			}
+			format, err := formatArgs(args)
+			if err != nil {
+				return err
+			}
+			return ExploreInterleavings(ctx, w, format, amounts, show, args.Bool("lost-only"))
+		},
+	})
+}
+
+const interleavingsInfo = `
+ INTERLEAVING EXPLORER
+ _____________________
+
++-{ Context }-----------------------------------------------------------------------------------------------+
+|                                                                                                           |
+| The critical section of the Financial Lack Race Condition Simulation, a.balance = a.balance + amount, is  |
+| really two steps: a read of the balance into a register of the goroutine, then a write of the register    |
+| plus the amount back into the balance. The scheduler may switch goroutines between any two steps.         |
+|                                                                                                           |
+| Rather than waiting for the real scheduler to hit the special outcome, every possible interleaving of     |
+| those steps was enumerated below and run on a model of the bank account starting at 0.                    |
+|                                                                                                           |
+%s|                                                                                                           |
+%s|                                                                                                           |
++-{ Schedules }---------------------------------------------------------------------------------------------+
+|                                                                                                           |
+%s|                                                                                                           |
++-----------------------------------------------------------------------------------------------------------+
+`
+
+// depositorNames names the modelled depositors, the initials being
+// used to write down schedules.
+var depositorNames = []string{"Alice", "Bob", "Carol", "Dave", "Erin"}
+
+// amountsArgs returns the amounts given by the "amounts" arg.
+func amountsArgs(args Args) ([]int, error) {
+	var amounts []int
+	for _, f := range strings.Split(args.String("amounts"), ",") {
+		amount, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil || amount <= 0 {
+			return nil, usagef("-amounts must be positive integers, got %q", f)
+		}
+		amounts = append(amounts, amount)
+	}
+	if len(amounts) < 2 || len(amounts) > len(depositorNames) {
+		return nil, usagef("-amounts must have 2 to %d amounts, got %d", len(depositorNames), len(amounts))
+	}
+	return amounts, nil
+}
+
+// bank models an account starting at 0 and goroutines depositing into
+// it, a deposit being a read step followed by a write step.
+type bank struct {
+	amounts   []int
+	balance   int
+	deposits  uint // deposits included in balance, bit i for depositor i
+	registers []int
+	pending   []uint // deposits included in every register
+	pcs       []int  // next step of every depositor: 0 read, 1 write, 2 done
+}
+
+func newBank(amounts []int) *bank {
+	return &bank{
+		amounts:   amounts,
+		registers: make([]int, len(amounts)),
+		pending:   make([]uint, len(amounts)),
+		pcs:       make([]int, len(amounts)),
+	}
+}
+
+// steps is the number of steps of every depositor.
+const steps = 2
+
+// done reports whether depositor i took all its steps.
+func (b *bank) done(i int) bool {
+	return b.pcs[i] == steps
+}
+
+// step runs the next step of depositor i and returns its name,
+// e.g. "A.read".
+func (b *bank) step(i int) string {
+	name := depositorNames[i][:1]
+	switch b.pcs[i] {
+	case 0:
+		b.registers[i], b.pending[i] = b.balance, b.deposits
+		b.pcs[i]++
+		return name + ".read"
+	case 1:
+		b.balance, b.deposits = b.registers[i]+b.amounts[i], b.pending[i]|1<<uint(i)
+		b.pcs[i]++
+		return name + ".write"
+	}
+	panic("smt: step of a depositor already done")
+}
+
+// lost returns the names of the depositors whose deposit is missing
+// from the balance.
+func (b *bank) lost() []string {
+	var names []string
+	for i := range b.amounts {
+		if b.done(i) && b.deposits&(1<<uint(i)) == 0 {
+			names = append(names, depositorNames[i])
+		}
+	}
+	return names
+}
+
+// schedule is an interleaving of the steps of the depositors, every
+// element being the depositor taking the step.
+type schedule []int
+
+// run runs s on a new bank and returns the bank and the names of the
+// steps taken.
+func (s schedule) run(amounts []int) (*bank, []string) {
+	b := newBank(amounts)
+	var names []string
+	for _, i := range s {
+		names = append(names, b.step(i))
+	}
+	return b, names
+}
+
+// interleavings calls fn with every interleaving of the steps of n
+// depositors, stopping early if fn returns false.
+func interleavings(n int, fn func(schedule) bool) {
+	left := make([]int, n)
+	for i := range left {
+		left[i] = steps
+	}
+	s := make(schedule, 0, n*steps)
+	var walk func() bool
+	walk = func() bool {
+		if len(s) == cap(s) {
+			return fn(s)
+		}
+		for i := range left {
+			if left[i] == 0 {
+				continue
+			}
+			left[i]--
+			s = append(s, i)
+			more := walk()
+			s = s[:len(s)-1]
+			left[i]++
+			if !more {
+				return false
+			}
+		}
+		return true
+	}
+	walk()
+}
+
+// ExploreInterleavings runs every interleaving of the read and write steps
+// of depositors depositing amounts into an account, listing up to show of
+// them, 0 meaning all, or only those losing a deposit if lostOnly is true.
+func ExploreInterleavings(ctx context.Context, w io.Writer, format Format, amounts []int, show int, lostOnly bool) error {
+	var want int
+	for _, amount := range amounts {
+		want += amount
+	}
+	start := time.Now()
+	balances := make(map[int]int)
+	var total, lost, listed int
+	var listing strings.Builder
+	var lostSchedules []string
+	interleavings(len(amounts), func(s schedule) bool {
+		if ctx.Err() != nil {
+			return false
+		}
+		b, names := s.run(amounts)
+		total++
+		balances[b.balance]++
+		missing := b.lost()
+		if len(missing) > 0 {
+			lost++
+		}
+		if lostOnly && len(missing) == 0 || show > 0 && listed == show {
+			return true
+		}
+		listed++
+		note := ""
+		if len(missing) > 0 {
+			note = "<-- lost " + strings.Join(missing, ", ")
+			lostSchedules = append(lostSchedules, strings.Join(names, " "))
+		}
+		row := fmt.Sprintf("%5d  %s  balance %d  %s", total, strings.Join(names, " "), b.balance, note)
+		raceRows(&listing, row)
+		return true
+	})
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	listable := total
+	if lostOnly {
+		listable = lost
+	}
+	if listed < listable {
+		fmt.Fprintf(&listing, "| %-105s |\n", fmt.Sprintf("... %d more schedules not listed, see -show", listable-listed))
+	}
+
+	if format == JSON {
+		observed := make(map[string]int)
+		for balance, n := range balances {
+			observed[strconv.Itoa(balance)] = n
+		}
+		r := newResult("interleavings", "unsynchronized", map[string]interface{}{"amounts": amounts})
+		r.Expected, r.Observed, r.Attempts, r.Elapsed = want, observed, total, time.Since(start)
+		r.Details = map[string]interface{}{"lost_schedules": lost, "listed_lost_schedules": lostSchedules}
+		return writeResults(w, r)
+	}
+	var legend strings.Builder
+	writeLegend(&legend, amounts)
+	summary := boxRows(fmt.Sprintf("There are %d schedules, %d of them lose a deposit. The expected balance is %d.", total, lost, want))
+	fmt.Fprintf(w, interleavingsInfo, legend.String(), summary, listing.String())
+	return nil
+}
@@ -0,0 +1,202 @@
+package smt
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GoroutineCount is the number of goroutines before and after running
+// a simulation, along with the goroutines started meanwhile and still
+// running after it: the goroutines the simulation leaked, e.g. a monitor
+// goroutine nobody stopped.
+type GoroutineCount struct {
+	Before int         `json:"before"`
+	After  int         `json:"after"`
+	Leaks  []Goroutine `json:"leaks,omitempty"`
+}
+
+// Leaked returns the number of goroutines leaked.
+func (g GoroutineCount) Leaked() int {
+	return len(g.Leaks)
+}
+
+func (g GoroutineCount) String() string {
+	return fmt.Sprintf("goroutines: %d before, %d after, %d leaked", g.Before, g.After, g.Leaked())
+}
+
+// Goroutine is a goroutine found in a dump of the stacks of all of them.
+type Goroutine struct {
+	ID        int      `json:"id"`
+	State     string   `json:"state"`      // e.g. "chan send", "sync.Mutex.Lock"
+	Stack     []string `json:"stack"`      // function (file:line) of every frame, innermost first, without the runtime
+	CreatedBy string   `json:"created_by"` // function (file:line) that started the goroutine
+
+	funcs []string // full names of the functions of the frames
+}
+
+func (g Goroutine) String() string {
+	s := fmt.Sprintf("goroutine %d [%s]", g.ID, g.State)
+	for _, frame := range g.Stack {
+		s += "\n  " + frame
+	}
+	if g.CreatedBy != "" {
+		s += "\n  created by " + g.CreatedBy
+	}
+	return s
+}
+
+// Summary returns g on one line: the innermost function of this package
+// it runs, or else of any package, and where it was started.
+func (g Goroutine) Summary() string {
+	s := fmt.Sprintf("goroutine %d [%s]", g.ID, g.State)
+	for i, fn := range g.funcs {
+		if strings.HasPrefix(fn, ownPackage) || i == len(g.funcs)-1 {
+			s += " in " + g.Stack[i]
+			break
+		}
+	}
+	if g.CreatedBy != "" {
+		s += ", created by " + g.CreatedBy
+	}
+	return s
+}
+
+// runs reports whether g runs function fn, given by its full name, or a
+// function literal of fn.
+func (g Goroutine) runs(fn string) bool {
+	for _, f := range g.funcs {
+		if f == fn || strings.HasPrefix(f, fn+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// leakSettleTime is how long leakCheck waits for the goroutines a
+// simulation stopped to actually return.
+const leakSettleTime = 100 * time.Millisecond
+
+// leakCheck snapshots the goroutines now and returns a function that
+// snapshots them again, once the goroutines started meanwhile had time to
+// return, reporting those that did not.
+func leakCheck() func() GoroutineCount {
+	before := runtime.NumGoroutine()
+	running := make(map[int]bool)
+	for _, g := range goroutines() {
+		running[g.ID] = true
+	}
+	started := func() []Goroutine {
+		var leaks []Goroutine
+		for _, g := range goroutines() {
+			if !running[g.ID] {
+				leaks = append(leaks, g)
+			}
+		}
+		return leaks
+	}
+	return func() GoroutineCount {
+		deadline := time.Now().Add(leakSettleTime)
+		leaks := started()
+		for len(leaks) > 0 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+			leaks = started()
+		}
+		return GoroutineCount{Before: before, After: runtime.NumGoroutine(), Leaks: leaks}
+	}
+}
+
+// goroutines returns the goroutines running now, but the calling one.
+func goroutines() []Goroutine {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	var all []Goroutine
+	for i, dump := range strings.Split(string(buf), "\n\n") {
+		if g, ok := parseGoroutine(dump); ok && i > 0 {
+			all = append(all, g)
+		}
+	}
+	return all
+}
+
+// parseGoroutine parses the stack of a goroutine: a header, e.g.
+// "goroutine 7 [chan send]:", followed by two lines per frame, the
+// function with its arguments, then its source line, the last frame
+// being where the goroutine was created.
+func parseGoroutine(dump string) (Goroutine, bool) {
+	var g Goroutine
+	lines := strings.Split(strings.TrimSpace(dump), "\n")
+	header := strings.TrimSuffix(lines[0], ":")
+	if !strings.HasPrefix(header, "goroutine ") {
+		return g, false
+	}
+	f := strings.SplitN(strings.TrimPrefix(header, "goroutine "), " ", 2)
+	id, err := strconv.Atoi(f[0])
+	if err != nil || len(f) < 2 {
+		return g, false
+	}
+	g.ID, g.State = id, strings.Trim(f[1], "[]")
+	for i := 1; i+1 < len(lines); i += 2 {
+		full, at := lines[i], sourceLine(strings.TrimSpace(lines[i+1]))
+		if strings.HasPrefix(full, "created by ") {
+			g.CreatedBy = stackFunc(strings.TrimPrefix(full, "created by ")) + " (" + at + ")"
+			continue
+		}
+		if strings.HasPrefix(full, "runtime.") || strings.HasPrefix(full, "internal/") || strings.HasPrefix(full, "sync.runtime_") {
+			continue
+		}
+		g.funcs = append(g.funcs, frameFunc(full))
+		g.Stack = append(g.Stack, stackFunc(full)+" ("+at+")")
+	}
+	return g, true
+}
+
+// funcName returns the name of function fn, e.g.
+// "github.com/moll-y/smt/src.relockDeposits".
+func funcName(fn interface{}) string {
+	return runtime.FuncForPC(reflect.ValueOf(fn).Pointer()).Name()
+}
+
+// ownPackage is the path of this package followed by a dot, which
+// stackFunc trims.
+var ownPackage = func() string {
+	name := funcName(funcName)
+	slash := strings.LastIndex(name, "/")
+	return name[:slash+strings.Index(name[slash:], ".")+1]
+}()
+
+// frameFunc returns the full name of the function of a frame of a stack
+// trace, without its arguments.
+func frameFunc(line string) string {
+	if i := strings.Index(line, " in goroutine "); i >= 0 {
+		line = line[:i] // created by fn in goroutine N
+	}
+	if strings.HasSuffix(line, ")") {
+		line = line[:strings.LastIndex(line, "(")]
+	}
+	return line
+}
+
+// stackFunc returns the function of a frame of a stack trace without its
+// arguments nor the path of its package, nor the package itself if it is
+// this one, e.g. "sync.(*Mutex).Lock" or "transfer".
+func stackFunc(line string) string {
+	line = frameFunc(line)
+	if strings.HasPrefix(line, ownPackage) {
+		return strings.TrimPrefix(line, ownPackage)
+	}
+	if i := strings.LastIndex(line, "/"); i >= 0 {
+		line = line[i+1:]
+	}
+	return line
+}
@@ -0,0 +1,117 @@
+package smt
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseGoroutine(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		dump string
+		ok   bool
+		want Goroutine
+	}{
+		{
+			name: "abandoned sender",
+			dump: `goroutine 7 [chan send]:
+github.com/moll-y/smt/src.balanceWithin.func1()
+	/root/module/src/leaks.go:171 +0x3c
+created by github.com/moll-y/smt/src.balanceWithin in goroutine 6
+	/root/module/src/leaks.go:170 +0x8c`,
+			ok: true,
+			want: Goroutine{
+				ID:        7,
+				State:     "chan send",
+				Stack:     []string{"balanceWithin.func1 (leaks.go:171)"},
+				CreatedBy: "balanceWithin (leaks.go:170)",
+				funcs:     []string{"github.com/moll-y/smt/src.balanceWithin.func1"},
+			},
+		},
+		{
+			name: "relocked mutex, before go1.21",
+			dump: `goroutine 21 [semacquire, 1 minutes]:
+sync.runtime_SemacquireMutex(0xc0000b6014, 0x0, 0x1)
+	/usr/local/go/src/runtime/sema.go:71 +0x47
+sync.(*Mutex).lockSlow(0xc0000b6010)
+	/usr/local/go/src/sync/mutex.go:138 +0x105
+sync.(*Mutex).Lock(...)
+	/usr/local/go/src/sync/mutex.go:81
+github.com/moll-y/smt/src.relockDeposit(0xc0000b6010, 0x64)
+	/root/module/src/deadlock.go:312 +0x8e
+created by github.com/moll-y/smt/src.relockDeposits
+	/root/module/src/deadlock.go:320 +0x6a`,
+			ok: true,
+			want: Goroutine{
+				ID:    21,
+				State: "semacquire, 1 minutes",
+				Stack: []string{
+					"sync.(*Mutex).lockSlow (mutex.go:138)",
+					"sync.(*Mutex).Lock (mutex.go:81)",
+					"relockDeposit (deadlock.go:312)",
+				},
+				CreatedBy: "relockDeposits (deadlock.go:320)",
+				funcs: []string{
+					"sync.(*Mutex).lockSlow",
+					"sync.(*Mutex).Lock",
+					"github.com/moll-y/smt/src.relockDeposit",
+				},
+			},
+		},
+		{
+			name: "main goroutine",
+			dump: `goroutine 1 [running]:
+main.main()
+	/root/module/main.go:6 +0x1c`,
+			ok: true,
+			want: Goroutine{
+				ID:    1,
+				State: "running",
+				Stack: []string{"main.main (main.go:6)"},
+				funcs: []string{"main.main"},
+			},
+		},
+		{
+			name: "not a goroutine",
+			dump: "panic: oops",
+		},
+	} {
+		g, ok := parseGoroutine(test.dump)
+		if ok != test.ok {
+			t.Errorf("%s: ok %v, want %v", test.name, ok, test.ok)
+			continue
+		}
+		if ok && !reflect.DeepEqual(g, test.want) {
+			t.Errorf("%s:\ngot  %#v\nwant %#v", test.name, g, test.want)
+		}
+	}
+}
+
+// startWaiting starts a goroutine waiting for stop to be closed.
+func startWaiting(stop chan struct{}) {
+	go func() {
+		<-stop
+	}()
+}
+
+func TestLeakCheck(t *testing.T) {
+	stop := make(chan struct{})
+	check := leakCheck()
+	startWaiting(stop)
+	leaks := check()
+	close(stop)
+	if leaks.Leaked() != 1 {
+		t.Fatalf("got %d leaks, want 1: %v", leaks.Leaked(), leaks.Leaks)
+	}
+	g := leaks.Leaks[0]
+	if g.State != "chan receive" {
+		t.Errorf("state %q, want chan receive", g.State)
+	}
+	if len(g.Stack) == 0 || !strings.HasPrefix(g.Stack[0], "startWaiting.func1 (leak_test.go:") {
+		t.Errorf("stack %q, want startWaiting.func1 first", g.Stack)
+	}
+	if !strings.HasPrefix(g.CreatedBy, "startWaiting (leak_test.go:") {
+		t.Errorf("created by %q, want startWaiting", g.CreatedBy)
+	}
+}
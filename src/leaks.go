@@ -0,0 +1,297 @@
+package smt
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	Register(Demo{
+		Name:        "leaks",
+		Category:    "sim",
+		Description: "leak goroutines the usual ways, find them with their creation site and fix them",
+		Params:      simParams(),
+		Run: func(ctx context.Context, w io.Writer, args Args) error {
+			format, err := formatArgs(args)
+			if err != nil {
+				return err
+			}
+			return Leaks(ctx, w, format)
+		},
+		Leaky: true,
+	})
+}
+
+const leaksInfo = `
+ GOROUTINE LEAKS
+ _______________
+
++-{ Context }-----------------------------------------------------------------------------------------------+
+|                                                                                                           |
+| A goroutine runs until its function returns. One that waits for something that never comes, or loops      |
+| for ever, is leaked: it holds its stack and whatever it references until the program exits, and a server  |
+| leaking a goroutine per request eventually runs out of memory. Nothing reports it, unless you look.       |
+|                                                                                                           |
+| smt looks: it dumps the stacks of all the goroutines before and after every demo, and the goroutines      |
+| started meanwhile, still running after the demo and a short settling time, are leaked. A leaked goroutine |
+| is reported with the function it is stuck in and the function that started it, e.g.                       |
+|                                                                                                           |
+|   goroutine 7 [chan send] in balanceWithin.func1 (leaks.go:171), created by balanceWithin (leaks.go:170)  |
+|                                                                                                           |
+| Each leaky pattern below runs first, then its fix. The goroutines the leaky ones leaked stay until smt    |
+| exits. As this demo leaks them on purpose, smt does not report them again once done.                      |
+|                                                                                                           |
++-{ Abandoned sender }--------------------------------------------------------------------------------------+
+|                                                                                                           |
+| A goroutine reads a slow balance while the caller gives up after a timeout. Nobody receives the balance   |
+| once the caller gave up, so the goroutine waits on its send for ever.                                     |
+|                                                                                                           |
+| func balanceWithin(account Account, timeout time.Duration) (int, bool) {                                  |
+|   balance := make(chan int)                                                                               |
+|   go func() {                                                                                             |
+|     balance <- account.Balance()                                                                          |
+|   }()                                                                                                     |
+|   select {                                                                                                |
+|   case b := <-balance:                                                                                    |
+|     return b, true                                                                                        |
+|   case <-time.After(timeout):                                                                             |
+|     return 0, false                                                                                       |
+|   }                                                                                                       |
+| }                                                                                                         |
+|                                                                                                           |
+%s|                                                                                                           |
+| A buffer of 1 lets the goroutine send without a receiver, and return.                                     |
+|                                                                                                           |
+|   balance := make(chan int, 1)                                                                            |
+|                                                                                                           |
+%s|                                                                                                           |
++-{ Endless loop }------------------------------------------------------------------------------------------+
+|                                                                                                           |
+| A spinner animates while the balance is read, as the spinner example once did, in a loop that never       |
+| ends: it outlives the reading.                                                                            |
+|                                                                                                           |
+| go func() {                                                                                               |
+|   for {                                                                                                   |
+|     for _, r := range "-\\|/" {                                                                           |
+|       fmt.Fprint(w, "\r"+string(r))                                                                       |
+|       time.Sleep(delay)                                                                                   |
+|     }                                                                                                     |
+|   }                                                                                                       |
+| }()                                                                                                       |
+| account.Balance()                                                                                         |
+|                                                                                                           |
+%s|                                                                                                           |
+| Give the loop a way out, a channel closed to stop it, and wait for it to return.                          |
+|                                                                                                           |
+| stop, stopped := make(chan struct{}), make(chan struct{})                                                 |
+| go func() {                                                                                               |
+|   defer close(stopped)                                                                                    |
+|   spinner(w, delay, stop) // returns once stop is closed                                                  |
+| }()                                                                                                       |
+| account.Balance()                                                                                         |
+| close(stop)                                                                                               |
+| <-stopped                                                                                                 |
+|                                                                                                           |
+%s|                                                                                                           |
++-{ Unclosed range }----------------------------------------------------------------------------------------+
+|                                                                                                           |
+| A goroutine deposits the amounts it receives from a channel until the channel is closed, which never      |
+| happens.                                                                                                  |
+|                                                                                                           |
+| deposits := make(chan int)                                                                                |
+| go func() {                                                                                               |
+|   for amount := range deposits {                                                                          |
+|     account.Deposit(amount)                                                                               |
+|   }                                                                                                       |
+| }()                                                                                                       |
+| for _, amount := range amounts {                                                                          |
+|   deposits <- amount                                                                                      |
+| }                                                                                                         |
+|                                                                                                           |
+%s|                                                                                                           |
+| Whoever sends closes the channel once done.                                                               |
+|                                                                                                           |
+| for _, amount := range amounts {                                                                          |
+|   deposits <- amount                                                                                      |
+| }                                                                                                         |
+| close(deposits)                                                                                           |
+| <-done                                                                                                    |
+|                                                                                                           |
+%s|                                                                                                           |
++-{ Unclosed monitor }--------------------------------------------------------------------------------------+
+|                                                                                                           |
+| A monitor account serves its deposits from a teller goroutine, which waits for the next request for       |
+| ever if nobody tells it the account is no longer used.                                                    |
+|                                                                                                           |
+| deposit(newMonitorAccount(), a, b)                                                                        |
+|                                                                                                           |
+%s|                                                                                                           |
+| Close what has a Close method, or a Stop one, once done with it.                                          |
+|                                                                                                           |
+| account := newMonitorAccount()                                                                            |
+| defer account.Close()                                                                                     |
+| deposit(account, a, b)                                                                                    |
+|                                                                                                           |
+%s|                                                                                                           |
++-----------------------------------------------------------------------------------------------------------+
+`
+
+// leakPattern is a way to leak goroutines, and how not to.
+type leakPattern struct {
+	name         string
+	leaky, fixed func()
+}
+
+var leakPatterns = []leakPattern{
+	{"abandoned-sender", abandonedSender, bufferedSender},
+	{"endless-loop", endlessLoop, stoppedLoop},
+	{"unclosed-range", unclosedRange, closedRange},
+	{"unclosed-monitor", unclosedMonitor, closedMonitor},
+}
+
+// leakTimeout is how long the patterns wait for a slow balance.
+const leakTimeout = 10 * time.Millisecond
+
+// slowAccount returns an account whose balance takes longer than
+// leakTimeout to read.
+func slowAccount() Account {
+	return &lockedAccount{lock: new(sync.RWMutex), reading: 5 * leakTimeout}
+}
+
+// balanceWithin returns the balance of account, or false if reading it
+// takes longer than timeout, its result sent on a channel of capacity n.
+func balanceWithin(account Account, timeout time.Duration, n int) (int, bool) {
+	balance := make(chan int, n)
+	go func() {
+		balance <- account.Balance()
+	}()
+	select {
+	case b := <-balance:
+		return b, true
+	case <-time.After(timeout):
+		return 0, false
+	}
+}
+
+func abandonedSender() { balanceWithin(slowAccount(), leakTimeout, 0) }
+
+func bufferedSender() { balanceWithin(slowAccount(), leakTimeout, 1) }
+
+// endlessLoop shows a spinner while reading a slow balance, the way the
+// spinner example once did.
+func endlessLoop() {
+	go func() {
+		for {
+			for _, r := range `-\|/` {
+				fmt.Fprintf(ioutil.Discard, "\r%c", r)
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}()
+	slowAccount().Balance()
+}
+
+// stoppedLoop shows a spinner while reading a slow balance, stopping it
+// once done.
+func stoppedLoop() {
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		spinner(ioutil.Discard, time.Millisecond, stop)
+	}()
+	slowAccount().Balance()
+	close(stop)
+	<-stopped
+}
+
+// depositAll deposits amounts into account, one at a time, from a
+// goroutine receiving them. If closing, it closes their channel once
+// sent and waits for the goroutine to return.
+func depositAll(account Account, amounts []int, closing bool) {
+	deposits := make(chan int)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for amount := range deposits {
+			account.Deposit(amount)
+		}
+	}()
+	for _, amount := range amounts {
+		deposits <- amount
+	}
+	if closing {
+		close(deposits)
+		<-done
+	}
+}
+
+func unclosedRange() { depositAll(new(mutexAccount), []int{100, 50}, false) }
+
+func closedRange() { depositAll(new(mutexAccount), []int{100, 50}, true) }
+
+func unclosedMonitor() {
+	deposit(newMonitorAccount(), 100, 50)
+}
+
+func closedMonitor() {
+	account := newMonitorAccount()
+	defer account.Close()
+	deposit(account, 100, 50)
+}
+
+// Leaks runs the leaky then the fixed variant of every leak pattern,
+// reporting the goroutines each one leaked.
+func Leaks(ctx context.Context, w io.Writer, format Format) error {
+	type outcome struct {
+		pattern, variant string
+		leaks            GoroutineCount
+		elapsed          time.Duration
+	}
+	var outcomes []outcome
+	for _, p := range leakPatterns {
+		for _, v := range []struct {
+			name string
+			run  func()
+		}{{"leaky", p.leaky}, {"fixed", p.fixed}} {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			check := leakCheck()
+			start := time.Now()
+			v.run()
+			elapsed := time.Since(start)
+			outcomes = append(outcomes, outcome{p.name, v.name, check(), elapsed})
+		}
+	}
+
+	if format == JSON {
+		var results []SimulationResult
+		for _, o := range outcomes {
+			r := newResult("leaks", o.variant, map[string]interface{}{"pattern": o.pattern})
+			r.Expected, r.Observed, r.Attempts, r.Elapsed, r.Goroutines = 0, o.leaks.Leaked(), 1, o.elapsed, o.leaks
+			results = append(results, r)
+		}
+		return writeResults(w, results...)
+	}
+	var rows []interface{}
+	for _, o := range outcomes {
+		var b strings.Builder
+		fmt.Fprintf(&b, "| %-105s |\n", fmt.Sprintf("%s: %d goroutine(s) leaked", o.variant, o.leaks.Leaked()))
+		for _, g := range o.leaks.Leaks {
+			// the summary of a goroutine is too long for a row
+			at := strings.SplitN(g.Summary(), ", created by ", 2)
+			fmt.Fprintf(&b, "| %-105s |\n", "  "+at[0])
+			if len(at) > 1 {
+				fmt.Fprintf(&b, "| %-105s |\n", "    created by "+at[1])
+			}
+		}
+		rows = append(rows, b.String())
+	}
+	fmt.Fprintf(w, leaksInfo, rows...)
+	return nil
+}
@@ -1,62 +1,286 @@
 package smt
 
-import "flag"
-
-var (
-	SFlag sFlag
-	FFlag sFlag
-	EFlag = flag.Int("e", 0, eUsage)
-	TFlag = flag.Int("t", 0, tUsage)
-	CFlag = flag.Int("c", 0, cUsage)
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
 )
 
-const (
-	eUsage = `
-Execute APP_ID demostration:
-
-    1 Echo Server
-    2 Clock Server
-    3 Disk Usage
-    4 Load Animation
-`
-	cUsage = `
-It follows the -e flag. Use it when you want to execute in a sequential 
-or concurrent fashion the Echo Server and Clock Server demostrations.
-
-    1 Sequential fashion
-    2 Concurrent fashion
-`
-	tUsage = `
-Execute TCP_CLIENT_ID:
-
-    1 Read-write TCP client
-    2 Read-only TCP client
-`
-	sUsage = `
-Execute SIMULATION_ID:
-
-    1 Financial Lack Race Condition Simulation
-    2 No Single Machine Word Race Condition Simulation
-`
-	fUsage = `
-Execute one simulations of corrects concurrent functions:
-
-    1 Avoid Race Condition Second and Third Way 
-`
-)
+// command is a node of the smt command tree. A command either has
+// subcommands, and dispatches its first argument to one of them, or
+// defines its own flags and runs.
+type command struct {
+	name  string
+	args  string // positional arguments, as shown in the usage line
+	short string // one line description, shown in the parent's help
+	long  string // description shown in the command's own help
+
+	// setup defines the command flags on fs and returns the function
+	// that runs the command with the remaining positional arguments.
+	setup  func(fs *flag.FlagSet) runFunc
+	subs   []*command
+	hidden bool // not listed in the parent's help
+}
 
-type sFlag []string
+type runFunc func(ctx context.Context, w io.Writer, args []string) error
 
-func (s *sFlag) String() string {
-	return "string representation"
+// usageError is returned when a command is invoked with wrong
+// arguments. The command help is printed along with the message.
+type usageError struct {
+	msg string
 }
 
-func (s *sFlag) Set(value string) error {
-	*s = append(*s, value)
+func (e usageError) Error() string {
+	return e.msg
+}
+
+func usagef(format string, a ...interface{}) error {
+	return usageError{fmt.Sprintf(format, a...)}
+}
+
+// commandTree returns the smt command tree: the builtin commands plus a
+// command per category of registered demos.
+func commandTree() *command {
+	root := &command{
+		name: "smt",
+		long: "smt provides a variety of concurrent programs to play with: servers, clients and race condition simulations.\n" +
+			"Demos can also be run as \"smt NAME\", see \"smt list\".",
+		subs: []*command{
+			{
+				name:  "list",
+				short: "list the demos and their flags",
+				long:  "List the demos grouped by category, along with their flags.",
+				setup: func(fs *flag.FlagSet) runFunc {
+					return func(ctx context.Context, w io.Writer, args []string) error {
+						if err := noArgs(args); err != nil {
+							return err
+						}
+						ListDemos(w)
+						return nil
+					}
+				},
+			},
+			{
+				name:  "client",
+				short: "run a client against a server demonstration",
+				long:  "Run a client against a server demonstration.",
+				subs: []*command{
+					clientCommand("echo", "read-write client, stdin goes to the server", EchoServerProof),
+					clientCommand("clock", "read-only client, server output goes to stdout", ClockServerProof),
+					demoCommand(Demo{
+						Name:        "clock-wall",
+						Description: "show the time of several clock servers side by side, e.g. NewYork=localhost:8010 Tokyo=localhost:8020",
+						Args:        "NAME=ADDR...",
+						Params: []Param{
+							{Name: "network", Default: "tcp", Usage: "network of the servers: tcp, tcp4, tcp6 or unix"},
+							{Name: "tick", Default: 1 * time.Second, Usage: "interval between two rows"},
+						},
+						Run: func(ctx context.Context, w io.Writer, args Args) error {
+							if len(args.Rest()) == 0 {
+								return usagef("missing clock servers")
+							}
+							if args.Duration("tick") <= 0 {
+								return usagef("-tick must be positive, got %v", args.Duration("tick"))
+							}
+							var clocks []Clock
+							for _, arg := range args.Rest() {
+								i := strings.Index(arg, "=")
+								if i <= 0 {
+									return usagef("bad clock server %q, want NAME=ADDR", arg)
+								}
+								addr, err := parseAddress(args.String("network"), arg[i+1:])
+								if err != nil {
+									return usageError{err.Error()}
+								}
+								clocks = append(clocks, Clock{Name: arg[:i], Addr: addr})
+							}
+							return ClockWall(ctx, w, clocks, args.Duration("tick"))
+						},
+					}),
+				},
+			},
+		},
+	}
+
+	groups := make(map[string]*command)
+	for _, d := range Demos() {
+		group, ok := groups[d.Category]
+		if !ok {
+			short, ok := categories[d.Category]
+			if !ok {
+				short = "run a " + d.Category + " demonstration"
+			}
+			group = &command{name: d.Category, short: short, long: strings.ToUpper(short[:1]) + short[1:] + "."}
+			groups[d.Category] = group
+			root.subs = append(root.subs, group)
+		}
+		group.subs = append(group.subs, demoCommand(d))
+	}
+	for _, d := range Demos() {
+		if root.sub(d.Name) == nil {
+			shortcut := demoCommand(d)
+			shortcut.hidden = true
+			root.subs = append(root.subs, shortcut)
+		}
+	}
+	return root
+}
+
+// demoCommand returns the command running d with its params as flags,
+// reporting the goroutines every run leaked unless d is Leaky.
+func demoCommand(d Demo) *command {
+	return &command{
+		name:  d.Name,
+		args:  d.Args,
+		short: d.Description,
+		long:  strings.ToUpper(d.Description[:1]) + d.Description[1:] + ".",
+		setup: func(fs *flag.FlagSet) runFunc {
+			for _, p := range d.Params {
+				switch v := p.Default.(type) {
+				case int:
+					fs.Int(p.Name, v, p.Usage)
+				case string:
+					fs.String(p.Name, v, p.Usage)
+				case bool:
+					fs.Bool(p.Name, v, p.Usage)
+				case time.Duration:
+					fs.Duration(p.Name, v, p.Usage)
+				}
+			}
+			return func(ctx context.Context, w io.Writer, rest []string) error {
+				if d.Args == "" {
+					if err := noArgs(rest); err != nil {
+						return err
+					}
+				}
+				args := Args{values: make(map[string]interface{}), rest: rest}
+				for _, p := range d.Params {
+					args.values[p.Name] = fs.Lookup(p.Name).Value.(flag.Getter).Get()
+				}
+				check := leakCheck()
+				err := d.Run(ctx, w, args)
+				if leaks := check(); leaks.Leaked() > 0 && !d.Leaky {
+					fmt.Fprintf(os.Stderr, "smt: %s leaked %d goroutine(s):\n", d.Name, leaks.Leaked())
+					for _, g := range leaks.Leaks {
+						fmt.Fprintf(os.Stderr, "  %s\n", g.Summary())
+					}
+				}
+				return err
+			}
+		},
+	}
+}
+
+func clientCommand(name, short string, fn func(context.Context, io.ReadWriter)) *command {
+	return demoCommand(Demo{
+		Name:        name,
+		Description: short,
+		Params:      addressParams("localhost"),
+		Run: func(ctx context.Context, w io.Writer, args Args) error {
+			addr, err := addressArgs(args)
+			if err != nil {
+				return err
+			}
+			return MakeProof(ctx, fn, addr)
+		},
+	})
+}
+
+func noArgs(args []string) error {
+	if len(args) != 0 {
+		return usagef("unexpected arguments: %s", strings.Join(args, " "))
+	}
+	return nil
+}
+
+// sub returns the subcommand called name, or nil.
+func (c *command) sub(name string) *command {
+	for _, sub := range c.subs {
+		if sub.name == name {
+			return sub
+		}
+	}
 	return nil
 }
 
-func init() {
-	flag.Var(&SFlag, "s", sUsage)
-	flag.Var(&FFlag, "f", fUsage)
+// execute runs the command addressed by args, path being the names of
+// the commands walked so far.
+func (c *command) execute(ctx context.Context, path []string, args []string, stdout, stderr io.Writer) error {
+	path = append(path, c.name)
+	if c.subs != nil {
+		if len(args) == 0 {
+			c.usage(path, stderr)
+			return usagef("missing command")
+		}
+		if args[0] == "-h" || args[0] == "-help" || args[0] == "--help" || args[0] == "help" {
+			c.usage(path, stderr)
+			return flag.ErrHelp
+		}
+		if sub := c.sub(args[0]); sub != nil {
+			return sub.execute(ctx, path, args[1:], stdout, stderr)
+		}
+		c.usage(path, stderr)
+		return usagef("unknown command %q", args[0])
+	}
+
+	fs := flag.NewFlagSet(strings.Join(path, " "), flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	run := c.setup(fs)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			c.usage(path, stderr)
+			return flag.ErrHelp
+		}
+		c.usage(path, stderr)
+		return usageError{err.Error()}
+	}
+	err := run(ctx, stdout, fs.Args())
+	if _, ok := err.(usageError); ok {
+		c.usage(path, stderr)
+	}
+	return err
+}
+
+// usage prints the help of the command.
+func (c *command) usage(path []string, w io.Writer) {
+	line := strings.Join(path, " ")
+	if c.subs != nil {
+		line += " COMMAND"
+	} else {
+		line += " [flags]"
+	}
+	if c.args != "" {
+		line += " " + c.args
+	}
+	fmt.Fprintf(w, "Usage: %s\n\n%s\n", line, c.long)
+
+	if c.subs != nil {
+		width := 0
+		for _, sub := range c.subs {
+			if !sub.hidden && len(sub.name) > width {
+				width = len(sub.name)
+			}
+		}
+		fmt.Fprintf(w, "\nCommands:\n")
+		for _, sub := range c.subs {
+			if !sub.hidden {
+				fmt.Fprintf(w, "    %-*s %s\n", width, sub.name, sub.short)
+			}
+		}
+		return
+	}
+	fs := flag.NewFlagSet(line, flag.ContinueOnError)
+	c.setup(fs)
+	var nflags int
+	fs.VisitAll(func(*flag.Flag) { nflags++ })
+	if nflags != 0 {
+		fmt.Fprintf(w, "\nFlags:\n")
+		fs.SetOutput(w)
+		fs.PrintDefaults()
+	}
 }
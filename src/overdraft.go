@@ -0,0 +1,217 @@
+package smt
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	Register(Demo{
+		Name:        "overdraft",
+		Category:    "sim",
+		Description: "withdraw concurrently from an account and see whether its balance goes negative",
+		Params: simParams(
+			strategyParam,
+			Param{Name: "balance", Default: 100, Usage: "initial balance of every account"},
+			Param{Name: "withdrawers", Default: 10, Usage: "goroutines withdrawing from every account"},
+			Param{Name: "amount", Default: 100, Usage: "amount withdrawn by every goroutine"},
+			Param{Name: "rounds", Default: 100, Usage: "times every strategy is tried"},
+		),
+		Run: func(ctx context.Context, w io.Writer, args Args) error {
+			strategies, err := strategyArgs(args)
+			if err != nil {
+				return err
+			}
+			balance, withdrawers, amount, rounds := args.Int("balance"), args.Int("withdrawers"), args.Int("amount"), args.Int("rounds")
+			if balance < 0 || withdrawers <= 0 || amount <= 0 || rounds <= 0 {
+				return usagef("-balance must not be negative, -withdrawers, -amount and -rounds must be positive")
+			}
+			format, err := formatArgs(args)
+			if err != nil {
+				return err
+			}
+			return OverdraftSimulation(ctx, w, format, strategies, balance, withdrawers, amount, rounds)
+		},
+	})
+}
+
+const overdraftInfo = `
+ OVERDRAFT SIMULATION
+ ____________________
+
++-{ Context }-----------------------------------------------------------------------------------------------+
+|                                                                                                           |
+| Withdrawing is not a blind update like depositing: the bank must first check there are enough funds,      |
+| then take the amount out of the balance. Such a check-then-act sequence is a race condition too, if       |
+| another withdrawal happens between the check and the act both see enough funds and both take the          |
+| amount out, so the balance goes negative.                                                                 |
+|                                                                                                           |
+%s|                                                                                                           |
++-{ Function }----------------------------------------------------------------------------------------------+
+|                                                                                                           |
+| func (a *yieldingAccount) Withdraw(amount int) bool {                                                     |
+|   if a.balance < amount {  <-- check                                                                      |
+|     return false                                                                                          |
+|   }                                                                                                       |
+|   runtime.Gosched() // let the other withdrawals check the balance                                        |
+|   a.balance = a.balance - amount  <-- act, the balance may have changed since the check                   |
+|   return true                                                                                             |
+| }                                                                                                         |
+|                                                                                                           |
+| runtime.Gosched only makes it happen sooner. The unsynchronized account of the other simulations does not |
+| yield: a withdrawal may still be preempted between the check and the act, but the window is so short the  |
+| race hardly shows up.                                                                                     |
+|                                                                                                           |
+| The mutex fix holds the lock from the check through the act, so nobody can withdraw in between:           |
+|                                                                                                           |
+| func (a *mutexAccount) Withdraw(amount int) bool {                                                        |
+|   a.mu.Lock()                                                                                             |
+|   defer a.mu.Unlock()                                                                                     |
+|   if a.balance < amount {                                                                                 |
+|     return false                                                                                          |
+|   }                                                                                                       |
+|   a.balance = a.balance - amount                                                                          |
+|   return true                                                                                             |
+| }                                                                                                         |
+|                                                                                                           |
+| The monitor fix sends the whole withdrawal to the teller, which replies whether it succeeded. Since the   |
+| teller serves one request at a time, it checks and acts with nobody in between:                           |
+|                                                                                                           |
+| func (a *monitorAccount) Withdraw(amount int) bool {                                                      |
+|   ok := make(chan bool)                                                                                   |
+|   a.withdrawals <- withdrawal{amount, ok}                                                                 |
+|   return <-ok                                                                                             |
+| }                                                                                                         |
+|                                                                                                           |
++-{ Outcomes }----------------------------------------------------------------------------------------------+
+|                                                                                                           |
+%s|                                                                                                           |
++-----------------------------------------------------------------------------------------------------------+
+`
+
+// overdraft is the outcome of the rounds of the overdraft simulation
+// run with a strategy.
+type overdraft struct {
+	strategy  string
+	overdrawn int // rounds ending with a negative balance
+	lowest    int // lowest final balance
+	most      int // most withdrawals succeeding in a round
+	elapsed   time.Duration
+}
+
+// OverdraftSimulation makes withdrawers goroutines withdraw amount at the
+// same time from an account holding balance, for rounds rounds and every
+// one of strategies, and reports whether any account was overdrawn.
+func OverdraftSimulation(ctx context.Context, w io.Writer, format Format, strategies []string, balance, withdrawers, amount, rounds int) error {
+	check := leakCheck()
+	allowed := balance / amount
+	if allowed > withdrawers {
+		allowed = withdrawers
+	}
+	var outcomes []overdraft
+	for _, strategy := range strategies {
+		o := overdraft{strategy: strategy, lowest: balance}
+		start := time.Now()
+		for i := 0; i < rounds; i++ {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			account, err := overdraftAccount(strategy)
+			if err != nil {
+				return err
+			}
+			account.Deposit(balance)
+			succeeded := withdraw(account, withdrawers, amount)
+			got := account.Balance()
+			closeAccount(account)
+			if got < 0 {
+				o.overdrawn++
+			}
+			if got < o.lowest {
+				o.lowest = got
+			}
+			if succeeded > o.most {
+				o.most = succeeded
+			}
+		}
+		o.elapsed = time.Since(start)
+		outcomes = append(outcomes, o)
+	}
+	leaks := check()
+
+	if format == JSON {
+		params := map[string]interface{}{"balance": balance, "withdrawers": withdrawers, "amount": amount}
+		var results []SimulationResult
+		for _, o := range outcomes {
+			r := newResult("overdraft", o.strategy, params)
+			r.Expected, r.Observed = balance-allowed*amount, o.lowest
+			r.Attempts, r.Elapsed, r.Goroutines = rounds, o.elapsed, leaks
+			r.Details = map[string]interface{}{"overdrawn_rounds": o.overdrawn, "most_withdrawals": o.most}
+			results = append(results, r)
+		}
+		return writeResults(w, results...)
+	}
+	var outcome strings.Builder
+	fmt.Fprintf(&outcome, "| %-16s %12s %12s %16s %14s%31s |\n", "strategy", "overdrawn", "lowest", "most withdrawals", "elapsed", "")
+	for _, o := range outcomes {
+		fmt.Fprintf(&outcome, "| %-16s %12s %12d %16d %14v%31s |\n", o.strategy, fmt.Sprintf("%d/%d", o.overdrawn, rounds), o.lowest, o.most, o.elapsed.Round(time.Microsecond), "")
+	}
+	setup := boxRows(fmt.Sprintf("Every round below started an account of every strategy with %d and made %d goroutines withdraw %d "+
+		"from it at the same time. At most %d of them should succeed, and the balance should never go below 0.",
+		balance, withdrawers, amount, allowed))
+	fmt.Fprintf(w, overdraftInfo, setup, outcome.String())
+	fmt.Fprintln(w, leaks)
+	return nil
+}
+
+// yieldingAccount is an unsynchronized account whose withdrawals let
+// the other goroutines run between the check and the act, so that the
+// overdraft simulation shows the race without running for ages.
+type yieldingAccount struct {
+	racyAccount
+}
+
+func (a *yieldingAccount) Withdraw(amount int) bool {
+	if a.balance < amount {
+		return false
+	}
+	runtime.Gosched() // let the other withdrawals check the balance
+	a.balance = a.balance - amount
+	return true
+}
+
+// overdraftAccount returns an empty account synchronized with strategy,
+// a yieldingAccount for the unsynchronized one.
+func overdraftAccount(strategy string) (Account, error) {
+	if strategy == "unsynchronized" {
+		return new(yieldingAccount), nil
+	}
+	return NewAccount(strategy)
+}
+
+// withdraw makes n goroutines withdraw amount from account at the same
+// time and returns how many of them succeeded.
+func withdraw(account Account, n, amount int) int {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var succeeded int
+
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			if account.Withdraw(amount) {
+				mu.Lock()
+				succeeded++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return succeeded
+}
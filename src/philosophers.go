@@ -0,0 +1,507 @@
+package smt
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+func init() {
+	var names []string
+	for _, s := range diningSolutions {
+		names = append(names, s.name)
+	}
+	Register(Demo{
+		Name:        "philosophers",
+		Category:    "sim",
+		Description: "seat the dining philosophers, watch the naive ones deadlock and the others eat",
+		Params: simParams(
+			Param{Name: "solution", Default: "all", Usage: "solution to run: all or one of " + strings.Join(names, ", ")},
+			Param{Name: "philosophers", Default: 5, Usage: "number of philosophers, and forks"},
+			Param{Name: "think", Default: 10 * time.Millisecond, Usage: "time a philosopher thinks between meals"},
+			Param{Name: "eat", Default: 10 * time.Millisecond, Usage: "time a meal takes"},
+			Param{Name: "duration", Default: 2 * time.Second, Usage: "time every solution runs for"},
+			Param{Name: "stall", Default: 500 * time.Millisecond, Usage: "time without a meal the watchdog calls a deadlock"},
+		),
+		Run: func(ctx context.Context, w io.Writer, args Args) error {
+			solutions := diningSolutions
+			if s := args.String("solution"); s != "all" {
+				solutions = nil
+				for _, solution := range diningSolutions {
+					if solution.name == s {
+						solutions = append(solutions, solution)
+					}
+				}
+				if solutions == nil {
+					return usagef("unknown solution %q, want all or one of %s", s, strings.Join(names, ", "))
+				}
+			}
+			n := args.Int("philosophers")
+			if n < 2 {
+				return usagef("-philosophers must be at least 2, got %d", n)
+			}
+			think, eat, duration, stall := args.Duration("think"), args.Duration("eat"), args.Duration("duration"), args.Duration("stall")
+			if think < 0 || eat < 0 || duration <= 0 || stall <= 0 {
+				return usagef("-think and -eat must not be negative, -duration and -stall must be positive")
+			}
+			format, err := formatArgs(args)
+			if err != nil {
+				return err
+			}
+			return DiningPhilosophers(ctx, w, format, solutions, n, think, eat, duration, stall)
+		},
+	})
+}
+
+const diningPhilosophersInfo = `
+ DINING PHILOSOPHERS SIMULATION
+ ______________________________
+
++-{ Definition }--------------------------------------------------------------------------------------------+
+|                                                                                                           |
+| A deadlock is a situation in which every goroutine of a group waits for another one of the group, for     |
+| instance to release a lock, so none of them can ever proceed. Starvation is a milder situation in which   |
+| some goroutines proceed while another one waits, again and again, for its turn.                           |
+|                                                                                                           |
++-{ Context }-----------------------------------------------------------------------------------------------+
+|                                                                                                           |
+| Philosophers sit at a round table, with a fork between every two of them. A philosopher thinks, then gets |
+| hungry and eats, which takes both the forks beside them, then thinks again, and so on. Forks are locks,   |
+| philosophers are goroutines.                                                                              |
+|                                                                                                           |
+%s|                                                                                                           |
+%s+-----------------------------------------------------------------------------------------------------------+
+`
+
+// reach is the time a philosopher takes to reach for the second fork
+// once holding the first one, which lets the others pick their first
+// fork meanwhile.
+const reach = time.Millisecond
+
+// diningSolution is a way for the philosophers to share the forks.
+type diningSolution struct {
+	name        string
+	description string
+	setup       func(d *dinner)        // prepare d before the philosophers sit, if not nil
+	dine        func(d *dinner, p int) // run philosopher p until d is over
+}
+
+var diningSolutions = []diningSolution{
+	{"naive", "Every philosopher picks the fork on their left, then the fork on their right. If all of them pick " +
+		"their left fork at once, all of them wait for their right fork forever: a deadlock.", nil, naivePhilosopher},
+	{"ordered", "Every philosopher picks the lower numbered of their forks first. The last philosopher picks their " +
+		"right fork first, so the cycle of philosophers waiting for each other can not close.", nil, orderedPhilosopher},
+	{"waiter", "A waiter lets at most all but one philosopher at the table at once, so at least one of them " +
+		"can always pick both forks.", nil, waiterPhilosopher},
+	{"chandy-misra", "Every fork is clean or dirty. A philosopher gives a dirty fork to a hungry neighbor asking " +
+		"for it, after cleaning it, but keeps a clean one until eating with it dirties it. Forks start dirty, " +
+		"with the lower numbered neighbor, and the hungry philosophers eat in turns.", chandyMisraSetup, chandyMisraPhilosopher},
+}
+
+// dinner is the table the philosophers share. Philosopher p sits between
+// fork p, on their left, and fork p+1, on their right.
+type dinner struct {
+	n          int
+	think, eat time.Duration
+	forks      []chan struct{} // holds a token while the fork is on the table
+	seats      chan struct{}   // seats the waiter lets philosophers take
+	over       chan struct{}   // closed once the dinner is over
+	meals      int64           // meals eaten so far, for the watchdog
+
+	mu       sync.Mutex
+	cond     *sync.Cond // signaled when a chandy-misra fork changes
+	diners   []diner
+	clean    []bool // chandy-misra forks: whether the fork is clean
+	owner    []int  // chandy-misra forks: the philosopher holding it
+	finished bool
+}
+
+// diner is what a philosopher did and is doing.
+type diner struct {
+	meals   int
+	hungry  time.Time     // when the philosopher got hungry, zero if not
+	longest time.Duration // longest time hungry
+	holds   []int         // forks held
+	waits   int           // fork waited for, -1 if none
+	eating  bool
+}
+
+func newDinner(n int, think, eat time.Duration) *dinner {
+	d := &dinner{
+		n:      n,
+		think:  think,
+		eat:    eat,
+		forks:  make([]chan struct{}, n),
+		seats:  make(chan struct{}, n-1),
+		over:   make(chan struct{}),
+		diners: make([]diner, n),
+		clean:  make([]bool, n),
+		owner:  make([]int, n),
+	}
+	d.cond = sync.NewCond(&d.mu)
+	for f := range d.forks {
+		d.forks[f] = make(chan struct{}, 1)
+		d.forks[f] <- struct{}{}
+	}
+	for p := range d.diners {
+		d.diners[p].waits = -1
+	}
+	return d
+}
+
+// end ends the dinner, waking up every philosopher.
+func (d *dinner) end() {
+	close(d.over)
+	d.mu.Lock()
+	d.finished = true
+	d.cond.Broadcast()
+	d.mu.Unlock()
+}
+
+// sleep sleeps for t, reporting false if the dinner ended meanwhile.
+func (d *dinner) sleep(t time.Duration) bool {
+	timer := time.NewTimer(t)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-d.over:
+		return false
+	}
+}
+
+// thinkThenHunger makes philosopher p think, then get hungry. It reports
+// false if the dinner ended meanwhile.
+func (d *dinner) thinkThenHunger(p int) bool {
+	if !d.sleep(d.think) {
+		return false
+	}
+	d.mu.Lock()
+	d.diners[p].hungry = time.Now()
+	d.mu.Unlock()
+	return true
+}
+
+// take makes philosopher p pick fork f, waiting for it to be on the
+// table. It reports false if the dinner ended first.
+func (d *dinner) take(p, f int) bool {
+	d.mu.Lock()
+	d.diners[p].waits = f
+	d.mu.Unlock()
+	select {
+	case <-d.forks[f]:
+		d.mu.Lock()
+		d.diners[p].waits = -1
+		d.diners[p].holds = append(d.diners[p].holds, f)
+		d.mu.Unlock()
+		return true
+	case <-d.over:
+		return false
+	}
+}
+
+// putDown makes philosopher p put every fork they hold on the table.
+func (d *dinner) putDown(p int) {
+	d.mu.Lock()
+	holds := d.diners[p].holds
+	d.diners[p].holds, d.diners[p].waits = nil, -1
+	d.mu.Unlock()
+	for _, f := range holds {
+		d.forks[f] <- struct{}{}
+	}
+}
+
+// dine makes philosopher p eat a meal, reporting false if the dinner
+// ended meanwhile.
+func (d *dinner) dine(p int) bool {
+	d.mu.Lock()
+	s := &d.diners[p]
+	if waited := time.Since(s.hungry); waited > s.longest {
+		s.longest = waited
+	}
+	s.hungry, s.eating = time.Time{}, true
+	d.mu.Unlock()
+
+	ok := d.sleep(d.eat)
+
+	d.mu.Lock()
+	s.eating = false
+	if ok {
+		s.meals++
+	}
+	d.mu.Unlock()
+	if ok {
+		atomic.AddInt64(&d.meals, 1)
+	}
+	return ok
+}
+
+// eatWith makes philosopher p pick first then second, eat and put
+// both down, reporting false if the dinner ended meanwhile.
+func (d *dinner) eatWith(p, first, second int) bool {
+	defer d.putDown(p)
+	return d.take(p, first) && d.sleep(reach) && d.take(p, second) && d.dine(p)
+}
+
+func naivePhilosopher(d *dinner, p int) {
+	for d.thinkThenHunger(p) && d.eatWith(p, p, (p+1)%d.n) {
+	}
+}
+
+func orderedPhilosopher(d *dinner, p int) {
+	first, second := p, (p+1)%d.n
+	if first > second {
+		first, second = second, first
+	}
+	for d.thinkThenHunger(p) && d.eatWith(p, first, second) {
+	}
+}
+
+func waiterPhilosopher(d *dinner, p int) {
+	for d.thinkThenHunger(p) {
+		select {
+		case d.seats <- struct{}{}:
+		case <-d.over:
+			return
+		}
+		ok := d.eatWith(p, p, (p+1)%d.n)
+		<-d.seats
+		if !ok {
+			return
+		}
+	}
+}
+
+// chandyMisraSetup gives every fork, dirty, to the lower numbered of the
+// two philosophers beside it.
+func chandyMisraSetup(d *dinner) {
+	for f := range d.owner {
+		p := (f + d.n - 1) % d.n // fork f lies between philosophers f-1 and f
+		if f < p {
+			p = f
+		}
+		d.owner[f] = p
+		d.diners[p].holds = append(d.diners[p].holds, f)
+	}
+}
+
+// chandyMisraPhilosopher follows the rules of Chandy and Misra, with the
+// requests and the forks being handed over under a lock rather than sent
+// as messages: a hungry philosopher takes a fork from its neighbor
+// whenever the neighbor would give it away.
+func chandyMisraPhilosopher(d *dinner, p int) {
+	forks := []int{p, (p + 1) % d.n}
+	for d.thinkThenHunger(p) {
+		d.mu.Lock()
+		for !d.finished {
+			var missing []int
+			for _, f := range forks {
+				if o := d.owner[f]; o != p && !d.clean[f] && !d.diners[o].eating {
+					d.handOver(f, o, p)
+				}
+				if d.owner[f] != p {
+					missing = append(missing, f)
+				}
+			}
+			if len(missing) == 0 {
+				break
+			}
+			d.diners[p].waits = missing[0]
+			d.cond.Wait()
+		}
+		d.diners[p].waits = -1
+		finished := d.finished
+		d.mu.Unlock()
+		if finished || !d.dine(p) {
+			return
+		}
+		d.mu.Lock()
+		for _, f := range forks {
+			d.clean[f] = false
+		}
+		d.cond.Broadcast()
+		d.mu.Unlock()
+	}
+}
+
+// handOver cleans chandy-misra fork f and hands it from philosopher
+// from to philosopher to. d.mu must be held.
+func (d *dinner) handOver(f, from, to int) {
+	d.owner[f], d.clean[f] = to, true
+	holds := d.diners[from].holds[:0]
+	for _, g := range d.diners[from].holds {
+		if g != f {
+			holds = append(holds, g)
+		}
+	}
+	d.diners[from].holds = holds
+	d.diners[to].holds = append(d.diners[to].holds, f)
+	d.cond.Broadcast()
+}
+
+// dinnerOutcome is how a dinner went.
+type dinnerOutcome struct {
+	solution   diningSolution
+	deadlocked time.Duration // when the watchdog found the deadlock, 0 if none
+	diners     []diner       // what the philosophers were doing at the end
+	elapsed    time.Duration
+}
+
+// DiningPhilosophers seats n philosophers at a table with n forks, every
+// philosopher thinking for think then eating for eat with both the forks
+// beside them, over and over, sharing the forks as told by every one of
+// solutions in turn for duration. A watchdog ends the dinner early once
+// nobody ate for stall, reporting a deadlock.
+func DiningPhilosophers(ctx context.Context, w io.Writer, format Format, solutions []diningSolution, n int, think, eat, duration, stall time.Duration) error {
+	check := leakCheck()
+	var outcomes []dinnerOutcome
+	for _, solution := range solutions {
+		o := dinnerParty(ctx, solution, n, think, eat, duration, stall)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		outcomes = append(outcomes, o)
+	}
+	leaks := check()
+
+	if format == JSON {
+		params := map[string]interface{}{"philosophers": n, "think_ns": think, "eat_ns": eat, "duration_ns": duration, "stall_ns": stall}
+		var results []SimulationResult
+		for _, o := range outcomes {
+			var meals []int
+			var longest []time.Duration
+			var holds [][]int
+			for _, s := range o.diners {
+				meals, longest, holds = append(meals, s.meals), append(longest, s.longest), append(holds, s.holds)
+			}
+			r := newResult("philosophers", o.solution.name, params)
+			r.Expected, r.Observed, r.Attempts, r.Elapsed, r.Goroutines = "no deadlock", meals, 1, o.elapsed, leaks
+			r.Details = map[string]interface{}{"deadlocked": o.deadlocked > 0, "longest_hungry_ns": longest}
+			if o.deadlocked > 0 {
+				r.Details["deadlocked_after_ns"], r.Details["held_forks"] = o.deadlocked, holds
+			}
+			results = append(results, r)
+		}
+		return writeResults(w, results...)
+	}
+	var b strings.Builder
+	for _, o := range outcomes {
+		fmt.Fprintf(&b, "+-{ %s }%s+\n", o.solution.name, strings.Repeat("-", 102-len(o.solution.name)))
+		fmt.Fprintf(&b, "| %-105s |\n", "")
+		for _, line := range wrap(o.solution.description, 105) {
+			fmt.Fprintf(&b, "| %-105s |\n", line)
+		}
+		fmt.Fprintf(&b, "| %-105s |\n", "")
+		if o.deadlocked > 0 {
+			fmt.Fprintf(&b, "| %-105s |\n", fmt.Sprintf("DEADLOCK: nobody ate for %v, the watchdog stopped the dinner %v after it started, when:",
+				stall, o.deadlocked.Round(time.Millisecond)))
+		} else {
+			fmt.Fprintf(&b, "| %-105s |\n", fmt.Sprintf("No deadlock in %v.", o.elapsed.Round(time.Millisecond)))
+		}
+		fmt.Fprintf(&b, "| %-105s |\n", "")
+		fmt.Fprintf(&b, "| %-105s |\n", fmt.Sprintf("%-12s %8s %14s   %s", "philosopher", "meals", "longest hungry", "state"))
+		fewest, most := -1, 0
+		for p, s := range o.diners {
+			state := "thinking"
+			switch {
+			case s.eating:
+				state = "eating"
+			case s.waits >= 0:
+				state = fmt.Sprintf("waiting for fork %d", s.waits)
+			}
+			if len(s.holds) > 0 {
+				state += fmt.Sprintf(", holding fork %s", joinInts(s.holds, " and "))
+			}
+			fmt.Fprintf(&b, "| %-105s |\n", fmt.Sprintf("%-12d %8d %14v   %s", p, s.meals, s.longest.Round(time.Millisecond), state))
+			if fewest < 0 || s.meals < fewest {
+				fewest = s.meals
+			}
+			if s.meals > most {
+				most = s.meals
+			}
+		}
+		fmt.Fprintf(&b, "| %-105s |\n", "")
+		fmt.Fprintf(&b, "| %-105s |\n", fmt.Sprintf("Fewest meals %d, most meals %d.", fewest, most))
+		fmt.Fprintf(&b, "| %-105s |\n", "")
+	}
+	setup := boxRows(fmt.Sprintf("There were %d philosophers, thinking for %v and eating for %v, and every solution ran for %v. "+
+		"A watchdog called a deadlock once nobody ate for %v, and reported what every philosopher held.", n, think, eat, duration, stall))
+	fmt.Fprintf(w, diningPhilosophersInfo, setup, b.String())
+	fmt.Fprintln(w, leaks)
+	return nil
+}
+
+// dinnerParty runs a dinner with solution until duration passed, the
+// watchdog found a deadlock or ctx is done.
+func dinnerParty(ctx context.Context, solution diningSolution, n int, think, eat, duration, stall time.Duration) dinnerOutcome {
+	d := newDinner(n, think, eat)
+	if solution.setup != nil {
+		solution.setup(d)
+	}
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for p := 0; p < n; p++ {
+		go func(p int) {
+			defer wg.Done()
+			solution.dine(d, p)
+		}(p)
+	}
+
+	watched, stopWatching := context.WithCancel(ctx)
+	defer stopWatching()
+	stalled := watchdog(watched, func() int64 { return atomic.LoadInt64(&d.meals) }, stall)
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	o := dinnerOutcome{solution: solution}
+	start := time.Now()
+	select {
+	case <-timer.C:
+	case <-stalled:
+		o.deadlocked = time.Since(start)
+	case <-ctx.Done():
+	}
+	o.elapsed = time.Since(start)
+	d.mu.Lock()
+	for _, s := range d.diners {
+		s.holds = append([]int(nil), s.holds...)
+		if waited := time.Since(s.hungry); !s.hungry.IsZero() && waited > s.longest {
+			s.longest = waited // still hungry
+		}
+		o.diners = append(o.diners, s)
+	}
+	d.mu.Unlock()
+	d.end()
+	wg.Wait()
+	return o
+}
+
+// wrap splits s into lines of at most width characters.
+func wrap(s string, width int) []string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(s) {
+		if line != "" && len(line)+1+len(word) > width {
+			lines = append(lines, line)
+			line = ""
+		}
+		if line != "" {
+			line += " "
+		}
+		line += word
+	}
+	return append(lines, line)
+}
+
+// joinInts joins the decimal representations of ints with sep.
+func joinInts(ints []int, sep string) string {
+	s := make([]string, len(ints))
+	for i, n := range ints {
+		s[i] = fmt.Sprint(n)
+	}
+	return strings.Join(s, sep)
+}
@@ -0,0 +1,352 @@
+package smt
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	Register(Demo{
+		Name:        "producer-consumer",
+		Category:    "sim",
+		Description: "hand items from producers to consumers through channels and a condition variable queue",
+		Params: simParams(
+			Param{Name: "producers", Default: 4, Usage: "number of producer goroutines"},
+			Param{Name: "consumers", Default: 2, Usage: "number of consumer goroutines"},
+			Param{Name: "produce", Default: time.Millisecond, Usage: "time a producer takes to produce an item"},
+			Param{Name: "consume", Default: 2 * time.Millisecond, Usage: "time a consumer takes to consume an item"},
+			Param{Name: "capacities", Default: "1,16", Usage: "comma separated capacities of the buffered channels and queues"},
+			Param{Name: "duration", Default: time.Second, Usage: "time every queue is used for"},
+		),
+		Run: func(ctx context.Context, w io.Writer, args Args) error {
+			producers, consumers := args.Int("producers"), args.Int("consumers")
+			if producers <= 0 || consumers <= 0 {
+				return usagef("-producers and -consumers must be positive, got %d and %d", producers, consumers)
+			}
+			produce, consume, duration := args.Duration("produce"), args.Duration("consume"), args.Duration("duration")
+			if produce < 0 || consume < 0 {
+				return usagef("-produce and -consume must not be negative")
+			}
+			if duration < minSampling {
+				return usagef("-duration must be at least %v, got %v", minSampling, duration)
+			}
+			var capacities []int
+			for _, f := range strings.Split(args.String("capacities"), ",") {
+				c, err := strconv.Atoi(strings.TrimSpace(f))
+				if err != nil || c <= 0 {
+					return usagef("-capacities must be positive integers, got %q", f)
+				}
+				capacities = append(capacities, c)
+			}
+			format, err := formatArgs(args)
+			if err != nil {
+				return err
+			}
+			return ProducerConsumer(ctx, w, format, producers, consumers, produce, consume, capacities, duration)
+		},
+	})
+}
+
+const producerConsumerInfo = `
+ PRODUCER-CONSUMER SIMULATION
+ ____________________________
+
++-{ Context }-----------------------------------------------------------------------------------------------+
+|                                                                                                           |
+| Producers make items and consumers use them, each at their own pace, handing the items over through a     |
+| queue. An unbuffered channel hands every item directly from a producer to a consumer, so both wait for    |
+| each other. A buffered channel, or a bounded queue guarded by a mutex whose producers and consumers wait  |
+| on condition variables, lets the producers run ahead of the consumers until the queue is full.            |
+|                                                                                                           |
+%s|                                                                                                           |
++-{ Outcomes }----------------------------------------------------------------------------------------------+
+|                                                                                                           |
+%s|                                                                                                           |
+| blocked is the share of their time the producers spent waiting for room, occupancy the average number of  |
+| items queued. Over time, ' ' stands for an empty queue and '#' for a full one.                            |
+|                                                                                                           |
+| A buffer does not make the consumers any faster: when they are the bottleneck the buffer fills up and     |
+| the producers block anyway. It only absorbs bursts.                                                       |
+|                                                                                                           |
++-----------------------------------------------------------------------------------------------------------+
+`
+
+// itemQueue hands items from producers to consumers.
+type itemQueue interface {
+	put(item int) bool        // waits while the queue is full, false once it is stopped
+	get() (item int, ok bool) // waits while the queue is empty, false once it is stopped
+	len() int
+	stop() // wakes up every producer and consumer waiting
+}
+
+// chanQueue is a channel, unbuffered if its capacity is 0.
+type chanQueue struct {
+	items   chan int
+	stopped chan struct{}
+}
+
+func newChanQueue(capacity int) *chanQueue {
+	return &chanQueue{items: make(chan int, capacity), stopped: make(chan struct{})}
+}
+
+func (q *chanQueue) put(item int) bool {
+	select {
+	case q.items <- item:
+		return true
+	case <-q.stopped:
+		return false
+	}
+}
+
+func (q *chanQueue) get() (int, bool) {
+	select {
+	case item := <-q.items:
+		return item, true
+	case <-q.stopped:
+		return 0, false
+	}
+}
+
+func (q *chanQueue) len() int { return len(q.items) }
+
+func (q *chanQueue) stop() { close(q.stopped) }
+
+// condQueue is a bounded queue guarded by a mutex, its producers and
+// consumers waiting on condition variables for room or items.
+type condQueue struct {
+	mu       sync.Mutex
+	notFull  *sync.Cond
+	notEmpty *sync.Cond
+	items    []int
+	capacity int
+	stopped  bool
+}
+
+func newCondQueue(capacity int) *condQueue {
+	q := &condQueue{capacity: capacity}
+	q.notFull = sync.NewCond(&q.mu)
+	q.notEmpty = sync.NewCond(&q.mu)
+	return q
+}
+
+func (q *condQueue) put(item int) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.items) == q.capacity && !q.stopped {
+		q.notFull.Wait()
+	}
+	if q.stopped {
+		return false
+	}
+	q.items = append(q.items, item)
+	q.notEmpty.Signal()
+	return true
+}
+
+func (q *condQueue) get() (int, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.items) == 0 && !q.stopped {
+		q.notEmpty.Wait()
+	}
+	if q.stopped {
+		return 0, false
+	}
+	item := q.items[0]
+	q.items = q.items[1:]
+	q.notFull.Signal()
+	return item, true
+}
+
+func (q *condQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+func (q *condQueue) stop() {
+	q.mu.Lock()
+	q.stopped = true
+	q.notFull.Broadcast()
+	q.notEmpty.Broadcast()
+	q.mu.Unlock()
+}
+
+// occupancySamples is the number of times the occupancy of a queue is
+// sampled while it is used.
+const occupancySamples = 40
+
+// minSampling is the shortest time a queue may be used for, so that its
+// occupancy samples are a millisecond apart at least.
+const minSampling = occupancySamples * time.Millisecond
+
+// handOff is the outcome of using a queue.
+type handOff struct {
+	kind      string
+	capacity  int
+	consumed  int
+	blocked   time.Duration // time the producers spent waiting to put
+	occupancy []int         // samples of the items queued
+	elapsed   time.Duration
+}
+
+func (h handOff) throughput() float64 {
+	return float64(h.consumed) / h.elapsed.Seconds()
+}
+
+func (h handOff) averageOccupancy() float64 {
+	var sum int
+	for _, n := range h.occupancy {
+		sum += n
+	}
+	if len(h.occupancy) == 0 {
+		return 0
+	}
+	return float64(sum) / float64(len(h.occupancy))
+}
+
+// sparkline draws the occupancy samples, one character per sample, from
+// ' ' for an empty queue to '#' for a full one.
+func (h handOff) sparkline() string {
+	const levels = " .:-=+*#"
+	var b strings.Builder
+	for _, n := range h.occupancy {
+		if h.capacity == 0 {
+			b.WriteByte(levels[0])
+			continue
+		}
+		b.WriteByte(levels[n*(len(levels)-1)/h.capacity])
+	}
+	return b.String()
+}
+
+// ProducerConsumer makes producers goroutines hand items to consumers
+// goroutines, producing an item taking produce and consuming it taking
+// consume, for duration, through an unbuffered channel, then a buffered
+// channel and a mutex and condition variable queue of every one of
+// capacities. It reports the throughput, the time the producers spent
+// blocked and the occupancy of every queue over time.
+func ProducerConsumer(ctx context.Context, w io.Writer, format Format, producers, consumers int, produce, consume time.Duration, capacities []int, duration time.Duration) error {
+	check := leakCheck()
+	type queue struct {
+		kind     string
+		capacity int
+		new      func() itemQueue
+	}
+	queues := []queue{{"unbuffered", 0, func() itemQueue { return newChanQueue(0) }}}
+	for _, c := range capacities {
+		c := c
+		queues = append(queues, queue{"buffered", c, func() itemQueue { return newChanQueue(c) }})
+	}
+	for _, c := range capacities {
+		c := c
+		queues = append(queues, queue{"cond", c, func() itemQueue { return newCondQueue(c) }})
+	}
+
+	var outcomes []handOff
+	for _, q := range queues {
+		h := handOff{kind: q.kind, capacity: q.capacity}
+		h.consumed, h.blocked, h.occupancy, h.elapsed = useQueue(ctx, q.new(), producers, consumers, produce, consume, duration)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		outcomes = append(outcomes, h)
+	}
+	leaks := check()
+
+	if format == JSON {
+		params := map[string]interface{}{"producers": producers, "consumers": consumers, "produce_ns": produce, "consume_ns": consume}
+		var results []SimulationResult
+		for _, h := range outcomes {
+			r := newResult("producer-consumer", h.kind, params)
+			r.Observed, r.Attempts, r.Elapsed, r.Goroutines = h.consumed, 1, h.elapsed, leaks
+			r.Details = map[string]interface{}{
+				"capacity":          h.capacity,
+				"items_per_sec":     h.throughput(),
+				"producers_blocked": h.blocked.Seconds() / (float64(producers) * h.elapsed.Seconds()),
+				"occupancy":         h.occupancy,
+			}
+			results = append(results, r)
+		}
+		return writeResults(w, results...)
+	}
+	var outcome strings.Builder
+	fmt.Fprintf(&outcome, "| %-10s %8s %9s %10s %8s %9s  %-*s%2s |\n", "queue", "capacity", "consumed", "items/sec", "blocked", "occupancy", occupancySamples+2, "occupancy over time", "")
+	for _, h := range outcomes {
+		blocked := h.blocked.Seconds() / (float64(producers) * h.elapsed.Seconds())
+		fmt.Fprintf(&outcome, "| %-10s %8d %9d %10.0f %7.1f%% %9.2f  [%s]%2s |\n", h.kind, h.capacity, h.consumed, h.throughput(), 100*blocked, h.averageOccupancy(), h.sparkline(), "")
+	}
+	setup := boxRows(fmt.Sprintf("%d producers took %v to produce an item, %d consumers took %v to consume one, for %v per queue.",
+		producers, produce, consumers, consume, duration))
+	fmt.Fprintf(w, producerConsumerInfo, setup, outcome.String())
+	fmt.Fprintln(w, leaks)
+	return nil
+}
+
+// useQueue makes producers goroutines put items into q and consumers
+// goroutines get them for duration, or until ctx is done. It returns the
+// items consumed, the time the producers spent waiting to put, the
+// occupancy samples and the time elapsed.
+func useQueue(ctx context.Context, q itemQueue, producers, consumers int, produce, consume, duration time.Duration) (int, time.Duration, []int, time.Duration) {
+	var mu sync.Mutex
+	var consumed int
+	var blocked time.Duration
+	var wg sync.WaitGroup
+
+	wg.Add(producers + consumers)
+	for i := 0; i < producers; i++ {
+		go func() {
+			defer wg.Done()
+			var waited time.Duration
+			defer func() {
+				mu.Lock()
+				blocked += waited
+				mu.Unlock()
+			}()
+			for item := 0; ; item++ {
+				time.Sleep(produce)
+				start := time.Now()
+				ok := q.put(item)
+				waited += time.Since(start)
+				if !ok {
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < consumers; i++ {
+		go func() {
+			defer wg.Done()
+			for {
+				if _, ok := q.get(); !ok {
+					return
+				}
+				time.Sleep(consume)
+				mu.Lock()
+				consumed++
+				mu.Unlock()
+			}
+		}()
+	}
+
+	var occupancy []int
+	ticker := time.NewTicker(duration / occupancySamples)
+	start := time.Now()
+	for len(occupancy) < occupancySamples && ctx.Err() == nil {
+		select {
+		case <-ticker.C:
+			occupancy = append(occupancy, q.len())
+		case <-ctx.Done():
+		}
+	}
+	ticker.Stop()
+	elapsed := time.Since(start)
+	q.stop()
+	wg.Wait()
+	return consumed, blocked, occupancy, elapsed
+}
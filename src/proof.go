@@ -1,38 +1,192 @@
 package smt
 
 import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"net"
 	"os"
+	"strings"
+	"sync"
+	"time"
 )
 
 // MakeProof make easy to create a ClockServerProof and a EchoServerProof.
-func MakeProof(fn func(io.ReadWriter), port string) {
-	conn, err := net.Dial("tcp", "0.0.0.0:"+port)
+// It returns once fn does or, closing the connection, once ctx is done and
+// fn returned.
+func MakeProof(ctx context.Context, fn func(context.Context, io.ReadWriter), addr Address) error {
+	conn, err := addr.Dial()
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	defer conn.Close()
-	fn(conn)
+	done := make(chan struct{})
+	go func() {
+		fn(ctx, conn)
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		conn.Close()
+		<-done
+		return ctx.Err()
+	}
 }
 
 // ClockServerProof is a TCP read-only client. You can
 // use it to read from the ClockServer output.
-func ClockServerProof(src io.ReadWriter) {
+func ClockServerProof(ctx context.Context, src io.ReadWriter) {
 	mustCopy(os.Stdout, src)
 }
 
 // EchoServerProof is TCP read-write client. You can
-// use it to read from and write to the EchoServer.
-func EchoServerProof(src io.ReadWriter) {
-	go mustCopy(os.Stdout, src)
-	mustCopy(src, os.Stdin)
+// use it to read from and write to the EchoServer. Once
+// stdin is exhausted it closes its side of the connection,
+// if src allows it, and reads until the server hangs up.
+// It stops reading stdin once ctx is done.
+func EchoServerProof(ctx context.Context, src io.ReadWriter) {
+	stdin := openStdin()
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-stop:
+		}
+		stdin.Close()
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		mustCopy(os.Stdout, src)
+		close(done)
+	}()
+	mustCopy(src, stdin)
+	if c, ok := src.(interface{ CloseWrite() error }); ok && ctx.Err() == nil {
+		if err := c.CloseWrite(); err != nil {
+			log.Printf("could not half-close the connection: %v", err)
+		}
+	}
+	<-done
+}
+
+// openStdin returns the standard input opened anew, which unlike
+// os.Stdin stops a pending read when closed, or os.Stdin if it can not.
+func openStdin() io.ReadCloser {
+	f, err := os.Open("/dev/stdin")
+	if err != nil {
+		return ioutil.NopCloser(os.Stdin)
+	}
+	return f
 }
 
 func mustCopy(dst io.Writer, src io.Reader) {
-	if _, err := io.Copy(dst, src); err != nil {
+	if _, err := io.Copy(dst, src); err != nil && !errors.Is(err, net.ErrClosed) && !errors.Is(err, os.ErrClosed) {
 		log.Fatal(err)
 	}
 }
+
+// Clock is a clock server shown by ClockWall.
+type Clock struct {
+	Name string // e.g. "Tokyo"
+	Addr Address
+}
+
+// ClockWall connects to several ClockServer concurrently and writes
+// a single row with the latest time read from every one of them each
+// tick, e.g. "NewYork 10:00:01 | Tokyo 23:00:01 | London 15:00:01".
+// It returns once ctx is done or, with an error naming them, once every
+// server went away or could not be reached.
+func ClockWall(ctx context.Context, w io.Writer, clocks []Clock, tick time.Duration) error {
+	type reading struct {
+		clock int
+		time  string
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	readings := make(chan reading)
+	var wg sync.WaitGroup
+	for i, c := range clocks {
+		wg.Add(1)
+		go func(i int, addr Address) {
+			defer wg.Done()
+			readClock(ctx, addr, func(t string) {
+				select {
+				case readings <- reading{i, t}:
+				case <-ctx.Done():
+				}
+			})
+		}(i, c.Addr)
+	}
+	go func() {
+		wg.Wait()
+		close(readings)
+	}()
+	defer func() {
+		cancel()
+		for range readings {
+			// wait for the readers to hang up
+		}
+	}()
+
+	latest := make([]string, len(clocks))
+	for i := range latest {
+		latest[i] = "--:--:--"
+	}
+	printRow := func() {
+		row := make([]string, len(clocks))
+		for i, c := range clocks {
+			row[i] = c.Name + " " + latest[i]
+		}
+		fmt.Fprintln(w, strings.Join(row, " | "))
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case r, ok := <-readings:
+			if !ok {
+				// every server went away, or was never there
+				printRow()
+				down := make([]string, len(clocks))
+				for i, c := range clocks {
+					down[i] = c.Name + " (" + c.Addr.String() + ")"
+				}
+				return fmt.Errorf("every clock server is down: %s", strings.Join(down, ", "))
+			}
+			latest[r.clock] = r.time
+		case <-ticker.C:
+			printRow()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// readClock calls send with every time read from the clock server at
+// addr, and with "down" once the server can not be read anymore.
+func readClock(ctx context.Context, addr Address, send func(string)) {
+	conn, err := addr.Dial()
+	if err != nil {
+		send("down")
+		return
+	}
+	defer conn.Close()
+	go func() {
+		// ClockWall cancels ctx when it returns
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	input := bufio.NewScanner(conn)
+	for input.Scan() {
+		send(input.Text())
+	}
+	send("down")
+}
@@ -1,9 +1,16 @@
 package smt
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"os"
+	"io"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
 )
 
 const financialLackRaceConditionSimulationInfo = `
@@ -43,38 +50,38 @@ const financialLackRaceConditionSimulationInfo = `
 |                                                                                                           |
 | func financialLackRaceConditionSimulation(a, b int) (int, int) {                                          |
 |   var want, attemps int                                                                                   |
-|   restoreBalance()                                                                                        |
 |                                                                                                           |
 |   want = a + b                                                                                            |
 |   attemps = 0                                                                                             |
 |   for true {                                                                                              |
-|     var wg sync.WaitGroup                                                                                 |
-|                                                                                                           |
-|     wg.Add(2)                                                                                             |
-|     go func() {                                                                                           |
-|       setDeposit(a) <-- Race Condition here                                                               |
-|       wg.Done()                                                                                           |
-|     }()                                                                                                   |
-|     go func() {                                                                                           |
-|       setDeposit(b) <-- Race Condition here                                                               |
-|       wg.Done()                                                                                           |
-|     }()                                                                                                   |
-|     wg.Wait()                                                                                             |
 |     attemps++                                                                                             |
-|     if got := getBalance(); got != want {                                                                 |
+|     if got := deposit(new(racyAccount), a, b); got != want {                                              |
 |       return got, attemps                                                                                 |
 |     }                                                                                                     |
-|     restoreBalance()                                                                                      |
 |   }                                                                                                       |
 |   return 0, 0                                                                                             |
 | }                                                                                                         |
 |                                                                                                           |
+| func deposit(account Account, amounts ...int) int {                                                       |
+|   var wg sync.WaitGroup                                                                                   |
+|                                                                                                           |
+|   wg.Add(len(amounts))                                                                                    |
+|   for _, amount := range amounts {                                                                        |
+|     go func(amount int) {                                                                                 |
+|       account.Deposit(amount) <-- Race Condition here                                                     |
+|       wg.Done()                                                                                           |
+|     }(amount)                                                                                             |
+|   }                                                                                                       |
+|   wg.Wait()                                                                                               |
+|   return account.Balance()                                                                                |
+| }                                                                                                         |
+|                                                                                                           |
 +-{ Critical Section }--------------------------------------------------------------------------------------+
 |                                                                                                           |
 | This was the section responsible for the special outcome.                                                 |
 |                                                                                                           |
-| func setDeposit(amount int) {                                                                             |
-|   balance = balance + amount  <-- Critical Section                                                        |
+| func (a *racyAccount) Deposit(amount int) {                                                               |
+|   a.balance = a.balance + amount  <-- Critical Section                                                    |
 | }                                                                                                         |
 |                                                                                                           |
 +-----------------------------------------------------------------------------------------------------------+
@@ -121,9 +128,41 @@ const noSingleMachineWordRaceConditionSimulationInfo = `
 |   x[999999] = 1 -> NOTE: undefined behavior; memory corruption possible!                                  |
 | }                                                                                                         |
 |                                                                                                           |
++-{ Outcome }-----------------------------------------------------------------------------------------------+
+|                                                                                                           |
+| Writing past a chimera is exactly what we must not do, so the simulation below never uses the racy value. |
+%s|                                                                                                           |
+%s|                                                                                                           |
 +-----------------------------------------------------------------------------------------------------------+
 `
 
+// ledgerCode is the code of the ledger, the first way of avoiding the
+// race, shown by both avoidRaceCondition and avoidRaceConditionSimulation.
+const ledgerCode = `| func avoidDataRaceFirstWay(a, b int) int {                                                                |
+|   l := newLedger(a, b) // every write happens before the goroutines start                                 |
+|   balances := make([]int, 2)                                                                              |
+|   var wg sync.WaitGroup                                                                                   |
+|                                                                                                           |
+|   wg.Add(len(balances))                                                                                   |
+|   for i := range balances {                                                                               |
+|     go func(i int) {                                                                                      |
+|       balances[i] = l.Balance() // any number of goroutines may read                                      |
+|       wg.Done()                                                                                           |
+|     }(i)                                                                                                  |
+|   }                                                                                                       |
+|   wg.Wait()                                                                                               |
+|   return balances[0]                                                                                      |
+| }                                                                                                         |
+|                                                                                                           |
+| func newLedger(amounts ...int) *ledger {                                                                  |
+|   l := new(ledger)                                                                                        |
+|   for _, amount := range amounts {                                                                        |
+|     l.balance += amount                                                                                   |
+|   }                                                                                                       |
+|   return l                                                                                                |
+| }                                                                                                         |
+`
+
 const avoidRaceCondition = `
                                           --Before going through this section make sure you have executed 
                                                     the Financial Lack Race Condition Simulation already.--
@@ -138,34 +177,34 @@ const avoidRaceCondition = `
 |                                                                                                           |
 | func financialLackRaceConditionSimulation(a, b int) (int, int) {                                          |
 |   var want, attemps int                                                                                   |
-|   restoreBalance()                                                                                        |
 |                                                                                                           |
 |   want = a + b                                                                                            |
 |   attemps = 0                                                                                             |
 |   for true {                                                                                              |
-|     var wg sync.WaitGroup                                                                                 |
-|                                                                                                           |
-|     wg.Add(2)                                                                                             |
-|     go func() {                                                                                           |
-|       setDeposit(a) <-- Race Condition here                                                               |
-|       wg.Done()                                                                                           |
-|     }()                                                                                                   |
-|     go func() {                                                                                           |
-|       setDeposit(b) <-- Race Condition here                                                               |
-|       wg.Done()                                                                                           |
-|     }()                                                                                                   |
-|     wg.Wait()                                                                                             |
 |     attemps++                                                                                             |
-|     if got := getBalance(); got != want {                                                                 |
+|     if got := deposit(new(racyAccount), a, b); got != want {                                              |
 |       return got, attemps                                                                                 |
 |     }                                                                                                     |
-|     restoreBalance()                                                                                      |
 |   }                                                                                                       |
 |   return 0, 0                                                                                             |
 | }                                                                                                         |
 |                                                                                                           |
-| func setDeposit(amount int) {                                                                             |
-|   balance = balance + amount  <-- Critical Section                                                        |
+| func deposit(account Account, amounts ...int) int {                                                       |
+|   var wg sync.WaitGroup                                                                                   |
+|                                                                                                           |
+|   wg.Add(len(amounts))                                                                                    |
+|   for _, amount := range amounts {                                                                        |
+|     go func(amount int) {                                                                                 |
+|       account.Deposit(amount) <-- Race Condition here                                                     |
+|       wg.Done()                                                                                           |
+|     }(amount)                                                                                             |
+|   }                                                                                                       |
+|   wg.Wait()                                                                                               |
+|   return account.Balance()                                                                                |
+| }                                                                                                         |
+|                                                                                                           |
+| func (a *racyAccount) Deposit(amount int) {                                                               |
+|   a.balance = a.balance + amount  <-- Critical Section                                                    |
 | }                                                                                                         |
 |                                                                                                           |
 +-{ Fix it }------------------------------------------------------------------------------------------------+
@@ -176,6 +215,10 @@ const avoidRaceCondition = `
 | creating threads and never modify it again, then any number of threads may safely call the related        |
 | function. But what can we do if we need to modify the entries? Let's read a little bit more.              |
 |                                                                                                           |
+| Here Alice's and Bob's deposits are all known before any goroutine starts, so the balance is computed     |
+| once and only read afterwards:                                                                            |
+|                                                                                                           |
+` + ledgerCode + `|                                                                                                           |
 | 2) The second way to avoid data race is to avoid accesing the variable from multiple threads and confined |
 | it to a single thread. Since other threads cannot access the variable directly, they must use a channel   |
 | to send the confinnig thread a request to query or update the variable. This is what is meant by the Go   |
@@ -184,40 +227,46 @@ const avoidRaceCondition = `
 | Now let's fix our previous FinancialLackRaceConditionSimulation code with this approach.                  |
 |                                                                                                           |
 | func avoidDataRaceSecondWay(a, b int) int {                                                               |
-|   go Teller()                                                                                             |
-|   var wg sync.WaitGroup                                                                                   |
+|   account := newMonitorAccount()                                                                          |
+|   defer account.Close() // stop the monitor, or it leaks                                                  |
+|   return deposit(account, a, b)                                                                           |
+| }                                                                                                         |
 |                                                                                                           |
-|   wg.Add(2)                                                                                               |
-|   go func() {                                                                                             |
-|     setDeposits(a)                                                                                        |
-|     wg.Done()                                                                                             |
-|   }()                                                                                                     |
-|   go func() {                                                                                             |
-|     setDeposits(b)                                                                                        |
-|     wg.Done()                                                                                             |
-|   }()                                                                                                     |
+| func newMonitorAccount() *monitorAccount {                                                                |
+|   a := &monitorAccount{                                                                                   |
+|     deposits: make(chan int),                                                                             |
+|     balances: make(chan int),                                                                             |
+|     quit:     make(chan struct{}),                                                                        |
+|     done:     make(chan struct{}),                                                                        |
+|   }                                                                                                       |
+|   go a.teller() // start the monitor                                                                      |
+|   return a                                                                                                |
+| }                                                                                                         |
 |                                                                                                           |
-|   wg.Wait()                                                                                               |
-|   got := getBalances()                                                                                    |
-|   restoreBalance()                                                                                        |
-|   return got                                                                                              |
+| func (a *monitorAccount) Deposit(amount int) {                                                            |
+|   a.deposits <- amount                                                                                    |
 | }                                                                                                         |
 |                                                                                                           |
-| func setDeposits(amount int) {                                                                            |
-|   deposits <- amount                                                                                      |
+| func (a *monitorAccount) Balance() int {                                                                  |
+|   return <-a.balances                                                                                     |
 | }                                                                                                         |
 |                                                                                                           |
-| func getBalances() int {                                                                                  |
-|   return <-balances                                                                                       |
+| func (a *monitorAccount) Close() error {                                                                  |
+|   close(a.quit)                                                                                           |
+|   <-a.done // wait for the monitor to return                                                              |
+|   return nil                                                                                              |
 | }                                                                                                         |
 |                                                                                                           |
-| func teller() {                                                                                           |
+| func (a *monitorAccount) teller() {                                                                       |
+|   defer close(a.done)                                                                                     |
 |   var balance int                                                                                         |
 |   for {                                                                                                   |
 |     select {                                                                                              |
-|     case amount := <-deposits:                                                                            |
+|     case amount := <-a.deposits:                                                                          |
 |       balance += amount                                                                                   |
-|     case balances <- balance:                                                                             |
+|     case a.balances <- balance:                                                                           |
+|     case <-a.quit:                                                                                        |
+|       return                                                                                              |
 |     }                                                                                                     |
 |   }                                                                                                       |
 | }                                                                                                         |
@@ -226,27 +275,41 @@ const avoidRaceCondition = `
 | time. This approach is known as mutual exclusion and is the subject of the next section.                  |
 |                                                                                                           |
 | func avoidDataRaceThirdWay(a, b int) int {                                                                |
-|   var wg sync.WaitGroup                                                                                   |
-|   var mu sync.Mutex                                                                                       |
+|   return deposit(new(mutexAccount), a, b)                                                                 |
+| }                                                                                                         |
 |                                                                                                           |
-|   restoreBalance()                                                                                        |
-|   wg.Add(2)                                                                                               |
-|   go func() {                                                                                             |
-|     mu.Lock()     <-- lock                                                                                |
-|     setDeposit(a)  <-- critical section                                                                   |
-|     mu.Unlock()   <-- unlock                                                                              |
-|     wg.Done()                                                                                             |
-|   }()                                                                                                     |
-|   go func() {                                                                                             |
-|     mu.Lock()     <-- lock                                                                                |
-|     setDeposit(b)    <-- critical section                                                                 |
-|     mu.Unlock()   <-- unlock                                                                              |
-|     wg.Done()                                                                                             |
-|   }()                                                                                                     |
-|   wg.Wait()                                                                                               |
-|   got := getBalance()                                                                                     |
-|   restoreBalance()                                                                                        |
-|   return got                                                                                              |
+| func (a *mutexAccount) Deposit(amount int) {                                                              |
+|   a.mu.Lock()     <-- lock                                                                                |
+|   a.balance = a.balance + amount  <-- critical section                                                    |
+|   a.mu.Unlock()   <-- unlock                                                                              |
+| }                                                                                                         |
+|                                                                                                           |
+| Reading the balance takes the lock too, so readers wait for each other although none of them changes      |
+| it. sync.RWMutex lets them share it, and "smt readers-writers" shows what that costs the writers.         |
+|                                                                                                           |
+| A special case of the third way are atomic operations, provided by the sync/atomic package: the hardware  |
+| itself lets a single thread at a time read and update a machine word, no lock needed. They are enough for |
+| a single counter like our balance, yet a withdrawal must still check the balance before updating it, so   |
+| it retries until nobody updated the balance in between, compare-and-swap:                                 |
+|                                                                                                           |
+| func avoidDataRaceAtomicWay(a, b int) int {                                                               |
+|   return deposit(new(atomicAccount), a, b)                                                                |
+| }                                                                                                         |
+|                                                                                                           |
+| func (a *atomicAccount) Deposit(amount int) {                                                             |
+|   atomic.AddInt64(&a.balance, int64(amount))  <-- read, add and write as a single operation               |
+| }                                                                                                         |
+|                                                                                                           |
+| func (a *atomicAccount) Withdraw(amount int) bool {                                                       |
+|   for {                                                                                                   |
+|     balance := atomic.LoadInt64(&a.balance)                                                               |
+|     if balance < int64(amount) {                                                                          |
+|       return false                                                                                        |
+|     }                                                                                                     |
+|     if atomic.CompareAndSwapInt64(&a.balance, balance, balance-int64(amount)) {                           |
+|       return true  <-- nobody changed the balance since it was loaded                                     |
+|     }                                                                                                     |
+|   }                                                                                                       |
 | }                                                                                                         |
 |                                                                                                           |
 +-----------------------------------------------------------------------------------------------------------+
@@ -267,221 +330,548 @@ const avoidRaceConditionSimulation = `
 |                                                                                                           |
 +-{ Context }-----------------------------------------------------------------------------------------------+
 |                                                                                                           |
-| In the previous Financial Lack Race Condition Simulation we have an issue. If Alice deposits %d, and 
-| Bob %d. when Alice or Bob wants to read their bank account, they could get an outcome like this %d 
-| instead of %d, which is the correct outcome.
-|                                                                                                           |
+%s|                                                                                                           |
 +-{ Outcomes }----------------------------------------------------------------------------------------------+
 |                                                                                                           |
 | Let's run the simulation again but this time we are going to use the adquired knowledge of Avoid Race     |
 | Condition section.                                                                                        |
 |                                                                                                           |
 +-----------------------------------------------------------------------------------------------------------+
-|
-| Executing the following function, which referes to the second way of avoiding race condition from [ Avoid 
-| Race Condition ] section, you would get %d, when Alice deposits %d and Bob %d. So this approach works 
-| correctly.                                                                                                |
 |                                                                                                           |
+%s|                                                                                                           |
+` + ledgerCode + `|                                                                                                           |
+%s|                                                                                                           |
 | func avoidDataRaceSecondWay(a, b int) int {                                                               |
-|   go Teller()                                                                                             |
-|   var wg sync.WaitGroup                                                                                   |
+|   account := newMonitorAccount()                                                                          |
+|   defer account.Close() // stop the monitor, or it leaks                                                  |
+|   return deposit(account, a, b)                                                                           |
+| }                                                                                                         |
 |                                                                                                           |
-|   wg.Add(2)                                                                                               |
-|   go func() {                                                                                             |
-|     setDeposits(a)                                                                                        |
-|     wg.Done()                                                                                             |
-|   }()                                                                                                     |
-|   go func() {                                                                                             |
-|     setDeposits(b)                                                                                        |
-|     wg.Done()                                                                                             |
-|   }()                                                                                                     |
+| func newMonitorAccount() *monitorAccount {                                                                |
+|   a := &monitorAccount{                                                                                   |
+|     deposits: make(chan int),                                                                             |
+|     balances: make(chan int),                                                                             |
+|     quit:     make(chan struct{}),                                                                        |
+|     done:     make(chan struct{}),                                                                        |
+|   }                                                                                                       |
+|   go a.teller() // start the monitor                                                                      |
+|   return a                                                                                                |
+| }                                                                                                         |
 |                                                                                                           |
-|   wg.Wait()                                                                                               |
-|   got := getBalances()                                                                                    |
-|   restoreBalance()                                                                                        |
-|   return got                                                                                              |
+| func (a *monitorAccount) Deposit(amount int) {                                                            |
+|   a.deposits <- amount                                                                                    |
 | }                                                                                                         |
 |                                                                                                           |
-| func setDeposits(amount int) {                                                                            |
-|   deposits <- amount                                                                                      |
+| func (a *monitorAccount) Balance() int {                                                                  |
+|   return <-a.balances                                                                                     |
 | }                                                                                                         |
 |                                                                                                           |
-| func getBalances() int {                                                                                  |
-|   return <-balances                                                                                       |
+| func (a *monitorAccount) Close() error {                                                                  |
+|   close(a.quit)                                                                                           |
+|   <-a.done // wait for the monitor to return                                                              |
+|   return nil                                                                                              |
 | }                                                                                                         |
 |                                                                                                           |
-| func Teller() {                                                                                           |
+| func (a *monitorAccount) teller() {                                                                       |
+|   defer close(a.done)                                                                                     |
 |   var balance int                                                                                         |
 |   for {                                                                                                   |
 |     select {                                                                                              |
-|     case amount := <-deposits:                                                                            |
+|     case amount := <-a.deposits:                                                                          |
 |       balance += amount                                                                                   |
-|     case balances <- balance:                                                                             |
+|     case a.balances <- balance:                                                                           |
+|     case <-a.quit:                                                                                        |
+|       return                                                                                              |
 |     }                                                                                                     |
 |   }                                                                                                       |
 | }                                                                                                         |
 |                                                                                                           |
-| Executing the following function, which referes to the third way of avoiding race condition from [ Avoid  | 
-| Race Condition ] section, you would get %d, when Alice deposits %d and Bob %d. So this approach works     
-| correctly as well.                                                                                        |
-|                                                                                                           |
+%s|                                                                                                           |
 | func avoidDataRaceThirdWay(a, b int) int {                                                                |
-|   var wg sync.WaitGroup                                                                                   |
-|   var mu sync.Mutex                                                                                       |
+|   return deposit(new(mutexAccount), a, b)                                                                 |
+| }                                                                                                         |
 |                                                                                                           |
-|   restoreBalance()                                                                                        |
-|   wg.Add(2)                                                                                               |
-|   go func() {                                                                                             |
-|     mu.Lock()                                                                                             |
-|     setDeposit(a)                                                                                         |
-|     mu.Unlock()                                                                                           |
-|     wg.Done()                                                                                             |
-|   }()                                                                                                     |
-|   go func() {                                                                                             |
-|     mu.Lock()                                                                                             |
-|     setDeposit(b)                                                                                         |
-|     mu.Unlock()                                                                                           |
-|     wg.Done()                                                                                             |
-|   }()                                                                                                     |
-|   wg.Wait()                                                                                               |
-|   got := getBalance()                                                                                     |
-|   restoreBalance()                                                                                        |
-|   return got                                                                                              |
+| func (a *mutexAccount) Deposit(amount int) {                                                              |
+|   a.mu.Lock()     <-- lock                                                                                |
+|   a.balance = a.balance + amount  <-- critical section                                                    |
+|   a.mu.Unlock()   <-- unlock                                                                              |
+| }                                                                                                         |
+|                                                                                                           |
+%s|                                                                                                           |
+| func avoidDataRaceAtomicWay(a, b int) int {                                                               |
+|   return deposit(new(atomicAccount), a, b)                                                                |
+| }                                                                                                         |
+|                                                                                                           |
+| func (a *atomicAccount) Deposit(amount int) {                                                             |
+|   atomic.AddInt64(&a.balance, int64(amount))                                                              |
 | }                                                                                                         |
 |                                                                                                           |
 +-----------------------------------------------------------------------------------------------------------+
 `
 
-func FinancialLackSimulation(alice, bob int) {
-	want := alice + bob
-	got, attemps := financialLackRaceConditionSimulation(alice, bob)
-	fmt.Fprintf(os.Stderr, financialLackRaceConditionSimulationInfo, alice, bob, got, want, got, bob, attemps)
+func init() {
+	Register(Demo{
+		Name:        "financial-lack",
+		Category:    "sim",
+		Description: "deposit Alice's and Bob's amounts concurrently until Bob's deposit is lost",
+		Params:      simParams(append(depositParams, maxAttemptsParam)...),
+		Run: func(ctx context.Context, w io.Writer, args Args) error {
+			alice, bob, err := depositArgs(args)
+			if err != nil {
+				return err
+			}
+			maxAttempts, err := maxAttemptsArgs(args)
+			if err != nil {
+				return err
+			}
+			format, err := formatArgs(args)
+			if err != nil {
+				return err
+			}
+			return FinancialLackSimulation(ctx, w, format, alice, bob, maxAttempts)
+		},
+	})
+	Register(Demo{
+		Name:        "no-single-word",
+		Category:    "sim",
+		Description: "tear slices, strings and interfaces apart and report the chimeras observed",
+		Params: simParams(
+			Param{Name: "attempts", Default: 1000000, Usage: "number of reads of every racy variable"},
+		),
+		Run: func(ctx context.Context, w io.Writer, args Args) error {
+			attempts := args.Int("attempts")
+			if attempts <= 0 {
+				return usagef("-attempts must be positive, got %d", attempts)
+			}
+			format, err := formatArgs(args)
+			if err != nil {
+				return err
+			}
+			return NoSingleMachineWordSimulation(w, format, attempts)
+		},
+	})
+	Register(Demo{
+		Name:        "avoid-race",
+		Category:    "sim",
+		Description: "deposit concurrently without losing money, using a ledger, a monitor goroutine, a mutex and atomics",
+		Params:      simParams(append(depositParams, maxAttemptsParam)...),
+		Run: func(ctx context.Context, w io.Writer, args Args) error {
+			alice, bob, err := depositArgs(args)
+			if err != nil {
+				return err
+			}
+			maxAttempts, err := maxAttemptsArgs(args)
+			if err != nil {
+				return err
+			}
+			format, err := formatArgs(args)
+			if err != nil {
+				return err
+			}
+			return AvoidDataRace(ctx, w, format, alice, bob, maxAttempts)
+		},
+	})
 }
 
-func NoSingleMachineWordSimulation() {
-	fmt.Fprintf(os.Stderr, noSingleMachineWordRaceConditionSimulationInfo)
+var depositParams = []Param{
+	{Name: "alice", Default: 100, Usage: "amount deposited by Alice"},
+	{Name: "bob", Default: 50, Usage: "amount deposited by Bob"},
 }
 
-func AvoidDataRace(alice, bob int) {
-	fmt.Fprintf(os.Stderr, avoidRaceCondition)
-	want := alice + bob
-	gotA := avoidDataRaceSecondWay(alice, bob)
-	gotB := avoidDataRaceThirdWay(alice, bob)
-	gotC, _ := financialLackRaceConditionSimulation(alice, bob)
-	fmt.Fprintf(os.Stderr, avoidRaceConditionSimulation, alice, bob, gotC, want, gotA, alice, bob, gotB, alice, bob)
-}
+var maxAttemptsParam = Param{Name: "max-attempts", Default: 1000000, Usage: "give up losing a deposit after this many attempts, 0 means never"}
 
-var (
-	balance  int
-	deposits = make(chan int) // send amount to deposits
-	balances = make(chan int) // receive balance
-)
-
-func restoreBalance() {
-	balance = 0
+// maxAttemptsArgs returns the value of the maxAttemptsParam arg.
+func maxAttemptsArgs(args Args) (int, error) {
+	n := args.Int("max-attempts")
+	if n < 0 {
+		return 0, usagef("-max-attempts must not be negative, got %d", n)
+	}
+	return n, nil
 }
 
-func setDeposit(amount int) {
-	// critical section
-	balance = balance + amount
+// depositArgs rejects amounts that would make a lost deposit
+// indistinguishable from a correct balance.
+func depositArgs(args Args) (alice, bob int, err error) {
+	alice, bob = args.Int("alice"), args.Int("bob")
+	if alice <= 0 || bob <= 0 {
+		return 0, 0, usagef("-alice and -bob must be positive, got %d and %d", alice, bob)
+	}
+	return alice, bob, nil
 }
 
-func getBalance() int {
-	return balance
+// FinancialLackSimulation deposits alice and bob concurrently into the same
+// bank account until Bob's deposit is lost, ctx is done or maxAttempts
+// were taken, 0 meaning no limit.
+func FinancialLackSimulation(ctx context.Context, w io.Writer, format Format, alice, bob, maxAttempts int) error {
+	check := leakCheck()
+	want := alice + bob
+	start := time.Now()
+	got, attemps, err := financialLackRaceConditionSimulation(ctx, alice, bob, maxAttempts)
+	// Bob's deposit may still be lost when it was not this time.
+	observed := err != errNoLostDeposit
+	if err != nil && observed {
+		return err
+	}
+	elapsed := time.Since(start)
+	leaks := check()
+	// The real schedule is gone, but the modelled one losing the same
+	// deposit can be replayed step by step.
+	var seed int64
+	var replayable bool
+	if observed {
+		seed, replayable = findSeed([]int{alice, bob}, got, 10000)
+	}
+	if format == JSON {
+		r := newResult("financial-lack", "unsynchronized", map[string]interface{}{"alice": alice, "bob": bob})
+		r.Expected, r.Observed, r.Attempts, r.Elapsed = want, got, attemps, elapsed
+		r.Goroutines = leaks
+		r.Details = map[string]interface{}{"race_observed": observed}
+		if replayable {
+			r.Details["replay_seed"] = seed
+		}
+		return writeResults(w, r)
+	}
+	if !observed {
+		fmt.Fprintf(w, "%v in %d attempts, try more -max-attempts or the race-frequency simulation\n", err, attemps)
+		fmt.Fprintln(w, leaks)
+		return nil
+	}
+	fmt.Fprintf(w, financialLackRaceConditionSimulationInfo, alice, bob, got, want, got, bob, attemps)
+	fmt.Fprintln(w, leaks)
+	if replayable {
+		fmt.Fprintf(w, "replay a schedule ending with %d step by step: smt replay -amounts %d,%d -seed %d\n", got, alice, bob, seed)
+	}
+	return nil
 }
 
-func setDeposits(amount int) {
-	deposits <- amount
+// NoSingleMachineWordSimulation races two goroutines assigning values of
+// different sizes to the same slice, string and interface variables, and
+// reports the torn values, chimeras, observed within the given attempts.
+func NoSingleMachineWordSimulation(w io.Writer, format Format, attempts int) error {
+	check := leakCheck()
+	races := noSingleMachineWordRaceConditionSimulation(attempts)
+	leaks := check()
+	if format == JSON {
+		var results []SimulationResult
+		for _, t := range races {
+			r := t.result()
+			r.Goroutines = leaks
+			results = append(results, r)
+		}
+		return writeResults(w, results...)
+	}
+	// the rows telling the number of reads are wrapped here, as it varies
+	var method strings.Builder
+	for _, line := range wrap(fmt.Sprintf("Two goroutines keep assigning A and B to the same variable while a third one performs %d reads of it, "+
+		"copying the raw header words (pointer, length, capacity or type) one by one and comparing them against "+
+		"the headers of A and B. A read that mixes words of both is a chimera.", attempts), 105) {
+		fmt.Fprintf(&method, "| %-105s |\n", line)
+	}
+	var outcome strings.Builder
+	for _, t := range races {
+		outcome.WriteString(t.String())
+	}
+	fmt.Fprintf(w, noSingleMachineWordRaceConditionSimulationInfo, method.String(), outcome.String())
+	fmt.Fprintln(w, leaks)
+	return nil
 }
 
-func getBalances() int {
-	return <-balances
-}
+// AvoidDataRace deposits alice and bob concurrently with the racy, the
+// immutable ledger, the monitor goroutine, the mutex and the atomic
+// approaches and compares their outcomes. The racy
+// approach is tried up to maxAttempts times, 0 meaning no limit, to show a
+// lost deposit.
+func AvoidDataRace(ctx context.Context, w io.Writer, format Format, alice, bob, maxAttempts int) error {
+	check := leakCheck()
+	want := alice + bob
+	params := map[string]interface{}{"alice": alice, "bob": bob}
+	start := time.Now()
+	gotF := avoidDataRaceFirstWay(alice, bob)
+	first := newResult("avoid-race", "immutable", params)
+	first.Expected, first.Observed, first.Attempts, first.Elapsed = want, gotF, 1, time.Since(start)
+
+	start = time.Now()
+	gotA := avoidDataRaceSecondWay(alice, bob)
+	second := newResult("avoid-race", "monitor", params)
+	second.Expected, second.Observed, second.Attempts, second.Elapsed = want, gotA, 1, time.Since(start)
+
+	start = time.Now()
+	gotB := avoidDataRaceThirdWay(alice, bob)
+	third := newResult("avoid-race", "mutex", params)
+	third.Expected, third.Observed, third.Attempts, third.Elapsed = want, gotB, 1, time.Since(start)
+
+	start = time.Now()
+	gotD := avoidDataRaceAtomicWay(alice, bob)
+	atomicWay := newResult("avoid-race", "atomic", params)
+	atomicWay.Expected, atomicWay.Observed, atomicWay.Attempts, atomicWay.Elapsed = want, gotD, 1, time.Since(start)
 
-// Monitor goroutine
-func teller() {
-	var balance int
-	for {
-		select {
-		case amount := <-deposits:
-			balance += amount
-		case balances <- balance:
+	start = time.Now()
+	gotC, attemps, err := financialLackRaceConditionSimulation(ctx, alice, bob, maxAttempts)
+	// Bob's deposit may still be lost when it was not this time.
+	observed := err != errNoLostDeposit
+	if err != nil && observed {
+		return err
+	}
+	racy := newResult("avoid-race", "unsynchronized", params)
+	racy.Expected, racy.Observed, racy.Attempts, racy.Elapsed = want, gotC, attemps, time.Since(start)
+	racy.Details = map[string]interface{}{"race_observed": observed}
+
+	leaks := check()
+	if format == JSON {
+		results := []SimulationResult{first, second, third, atomicWay, racy}
+		for i := range results {
+			results[i].Goroutines = leaks
 		}
+		return writeResults(w, results...)
+	}
+	issue := fmt.Sprintf("In the previous Financial Lack Race Condition Simulation we have an issue. If Alice deposits %d, and "+
+		"Bob %d. when Alice or Bob wants to read their bank account, they could get an outcome like this %d "+
+		"instead of %d, which is the correct outcome.", alice, bob, gotC, want)
+	if !observed {
+		issue = fmt.Sprintf("In the previous Financial Lack Race Condition Simulation we have an issue. If Alice deposits %d, and "+
+			"Bob %d. when Alice or Bob wants to read their bank account, they could get an outcome other than %d, "+
+			"which is the correct outcome. This time the race was not observed: no deposit was lost in %d attempts.",
+			alice, bob, want, attemps)
 	}
+	var issueRows strings.Builder
+	for _, line := range wrap(issue, 105) {
+		fmt.Fprintf(&issueRows, "| %-105s |\n", line)
+	}
+	fmt.Fprintf(w, avoidRaceCondition)
+	firstRows := boxRows(fmt.Sprintf("Executing the following function, which referes to the first way of avoiding race condition from [ Avoid "+
+		"Race Condition ] section, you would get %d, when Alice deposits %d and Bob %d. Nothing is written once the "+
+		"goroutines start, so there is nothing to race on.", gotF, alice, bob))
+	secondRows := boxRows(fmt.Sprintf("Executing the following function, which referes to the second way of avoiding race condition from [ Avoid "+
+		"Race Condition ] section, you would get %d, when Alice deposits %d and Bob %d. So this approach works correctly.", gotA, alice, bob))
+	thirdRows := boxRows(fmt.Sprintf("Executing the following function, which referes to the third way of avoiding race condition from [ Avoid "+
+		"Race Condition ] section, you would get %d, when Alice deposits %d and Bob %d. So this approach works correctly as well.", gotB, alice, bob))
+	atomicRows := boxRows(fmt.Sprintf("Executing the following function, which uses atomic operations as a special case of the third way, you "+
+		"would get %d, when Alice deposits %d and Bob %d.", gotD, alice, bob))
+	fmt.Fprintf(w, avoidRaceConditionSimulation, issueRows.String(), firstRows, secondRows, thirdRows, atomicRows)
+	fmt.Fprintln(w, leaks)
+	return nil
 }
 
+// deposit deposits every amount into account, each one from its own
+// goroutine, and returns the resulting balance.
+func deposit(account Account, amounts ...int) int {
+	var wg sync.WaitGroup
+
+	wg.Add(len(amounts))
+	for _, amount := range amounts {
+		go func(amount int) {
+			account.Deposit(amount)
+			wg.Done()
+		}(amount)
+	}
+	wg.Wait()
+	return account.Balance()
+}
+
+// errNoLostDeposit is returned by financialLackRaceConditionSimulation
+// when no deposit was lost within the attempts it was given.
+var errNoLostDeposit = errors.New("no deposit was lost")
+
 // FinancialLackRaceConditionSimulation always return the special outcome because
 // of race condition and the number of attemps that were taken to get that special
-// outcome. It takes two argument a, and b. Where a and b are the amounts that are
-// going to be deposited into the same bank account which always starts at 0.
-func financialLackRaceConditionSimulation(a, b int) (int, int) {
+// outcome, unless ctx is done or maxAttempts were taken first, 0 meaning no limit.
+// It takes two argument a, and b. Where a and b are the amounts that are going to
+// be deposited into the same bank account which always starts at 0.
+func financialLackRaceConditionSimulation(ctx context.Context, a, b, maxAttempts int) (int, int, error) {
 	var want, attemps int
-	restoreBalance()
 
 	want = a + b
 	attemps = 0
-	for true {
-		var wg sync.WaitGroup
-
-		wg.Add(2)
-		go func() {
-			setDeposit(a)
-			wg.Done()
-		}()
-		go func() {
-			setDeposit(b)
-			wg.Done()
-		}()
-		wg.Wait()
+	for ctx.Err() == nil {
+		if maxAttempts > 0 && attemps == maxAttempts {
+			return want, attemps, errNoLostDeposit
+		}
 		attemps++
-		if got := getBalance(); got != want {
-			return got, attemps
+		if got := deposit(new(racyAccount), a, b); got != want {
+			return got, attemps, nil
 		}
-		restoreBalance()
 	}
-	return 0, 0
+	return 0, attemps, ctx.Err()
 }
 
-func avoidDataRaceSecondWay(a, b int) int {
-	go teller() // start the monitor
+func avoidDataRaceFirstWay(a, b int) int {
+	l := newLedger(a, b) // every write happens before the goroutines start
+	balances := make([]int, 2)
 	var wg sync.WaitGroup
 
-	wg.Add(2)
-	go func() {
-		setDeposits(a)
-		wg.Done()
-	}()
-	go func() {
-		setDeposits(b)
-		wg.Done()
-	}()
-
+	wg.Add(len(balances))
+	for i := range balances {
+		go func(i int) {
+			balances[i] = l.Balance() // any number of goroutines may read
+			wg.Done()
+		}(i)
+	}
 	wg.Wait()
-	got := getBalances()
-	restoreBalance()
-	return got
+	return balances[0]
+}
+
+// ledger is an account whose deposits are all known when it is created
+// and which is never written afterwards, so that any number of
+// goroutines may read its balance at once.
+type ledger struct {
+	balance int
+}
+
+func newLedger(amounts ...int) *ledger {
+	l := new(ledger)
+	for _, amount := range amounts {
+		l.balance += amount
+	}
+	return l
+}
+
+func (l *ledger) Balance() int {
+	return l.balance
+}
+
+func avoidDataRaceSecondWay(a, b int) int {
+	account := newMonitorAccount()
+	defer account.Close()
+	return deposit(account, a, b)
 }
 
 func avoidDataRaceThirdWay(a, b int) int {
+	return deposit(new(mutexAccount), a, b)
+}
+
+func avoidDataRaceAtomicWay(a, b int) int {
+	return deposit(new(atomicAccount), a, b)
+}
+
+// tornWords is the outcome of racing two values of a multi-word type.
+type tornWords struct {
+	kind     string
+	a, b     string         // how the two racing values were built
+	attempts int            // reads performed
+	first    int            // read at which the first chimera was seen, 0 if none
+	torn     int            // reads that returned a chimera
+	chimeras map[string]int // which value every word came from -> times seen
+	elapsed  time.Duration
+}
+
+func (t tornWords) result() SimulationResult {
+	r := newResult("no-single-word", "unsynchronized", map[string]interface{}{
+		"kind": t.kind, "a": t.a, "b": t.b, "attempts": t.attempts,
+	})
+	r.Expected, r.Observed, r.Attempts, r.Elapsed = 0, t.torn, t.attempts, t.elapsed
+	r.Details = map[string]interface{}{"first_chimera": t.first, "chimeras": t.chimeras}
+	return r
+}
+
+func (t tornWords) String() string {
+	var lines []string
+	lines = append(lines, fmt.Sprintf("%-10s A = %s   B = %s", t.kind, t.a, t.b))
+	if t.torn == 0 {
+		lines = append(lines, fmt.Sprintf("%-10s no chimera in %d reads, try more attempts or a bigger GOMAXPROCS", "", t.attempts))
+	} else {
+		lines = append(lines, fmt.Sprintf("%-10s first chimera at read %d, %d chimeras in %d reads:", "", t.first, t.torn, t.attempts))
+		var combos []string
+		for combo := range t.chimeras {
+			combos = append(combos, combo)
+		}
+		sort.Strings(combos)
+		for _, combo := range combos {
+			lines = append(lines, fmt.Sprintf("%-10s %8dx %s", "", t.chimeras[combo], combo))
+		}
+	}
+
+	var b strings.Builder
+	for _, line := range lines {
+		fmt.Fprintf(&b, "| %-105s |\n", line)
+	}
+	return b.String()
+}
+
+// noSingleMachineWordRaceConditionSimulation races a slice, a string and an
+// interface, each one against a value of a different size or type.
+func noSingleMachineWordRaceConditionSimulation(attempts int) []tornWords {
+	var (
+		x  []int
+		s  string
+		i  interface{}
+		n              = 42
+		xa             = make([]int, 10)
+		xb             = make([]int, 1000000)
+		sa             = "Alice"
+		sb             = strings.Repeat("Bob", 1000)
+		ia interface{} = &n
+		ib interface{} = sa
+	)
+	return []tornWords{
+		tornWordRace("slice", "make([]int, 10)", "make([]int, 1000000)",
+			[]string{"data", "len", "cap"}, unsafe.Pointer(&x),
+			func() { x = xa }, func() { x = xb }, attempts),
+		tornWordRace("string", `"Alice"`, `strings.Repeat("Bob", 1000)`,
+			[]string{"data", "len"}, unsafe.Pointer(&s),
+			func() { s = sa }, func() { s = sb }, attempts),
+		tornWordRace("interface", "&n (*int)", `"Alice" (string)`,
+			[]string{"type", "data"}, unsafe.Pointer(&i),
+			func() { i = ia }, func() { i = ib }, attempts),
+	}
+}
+
+// tornWordRace keeps storing A and B into the variable at p from two goroutines
+// while the caller reads the variable back attempts times. Each read copies the
+// variable's words as plain uintptrs, so a chimera is only ever compared, never
+// dereferenced, indexed or seen by the garbage collector as a pointer.
+func tornWordRace(kind, a, b string, fields []string, p unsafe.Pointer, storeA, storeB func(), attempts int) tornWords {
+	words := func() []uintptr {
+		w := make([]uintptr, len(fields))
+		for j := range w {
+			w[j] = *(*uintptr)(unsafe.Pointer(uintptr(p) + uintptr(j)*unsafe.Sizeof(uintptr(0))))
+		}
+		return w
+	}
+	storeB()
+	wordsB := words()
+	storeA()
+	wordsA := words()
+
+	start := time.Now()
+	t := tornWords{kind: kind, a: a, b: b, attempts: attempts, chimeras: make(map[string]int)}
+	var stop int32
 	var wg sync.WaitGroup
-	var mu sync.Mutex
-
-	restoreBalance()
-	wg.Add(2)
-	go func() {
-		mu.Lock()
-		setDeposit(a)
-		mu.Unlock()
-		wg.Done()
-	}()
-	go func() {
-		mu.Lock()
-		setDeposit(b)
-		mu.Unlock()
-		wg.Done()
-	}()
+	for _, store := range []func(){storeA, storeB} {
+		wg.Add(1)
+		go func(store func()) {
+			defer wg.Done()
+			for atomic.LoadInt32(&stop) == 0 {
+				store() // <-- Race Condition here
+			}
+		}(store)
+	}
+
+	for attempt := 1; attempt <= attempts; attempt++ {
+		got := words()
+		var combo []string
+		var fromA, fromB int
+		for j, w := range got {
+			switch w {
+			case wordsA[j]:
+				fromA++
+				combo = append(combo, fields[j]+":A")
+			case wordsB[j]:
+				fromB++
+				combo = append(combo, fields[j]+":B")
+			default:
+				combo = append(combo, fields[j]+":?")
+			}
+		}
+		if fromA != len(got) && fromB != len(got) {
+			if t.first == 0 {
+				t.first = attempt
+			}
+			t.torn++
+			t.chimeras[strings.Join(combo, " ")]++
+		}
+	}
+	atomic.StoreInt32(&stop, 1)
 	wg.Wait()
-	got := getBalance()
-	restoreBalance()
-	return got
+	t.elapsed = time.Since(start)
+	return t
 }
@@ -0,0 +1,388 @@
+package smt
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+func init() {
+	Register(Demo{
+		Name:        "race-detector",
+		Category:    "sim",
+		Description: "run a simulation built with the race detector and summarize the races it reports",
+		Args:        "[-- FLAG...]",
+		Params: simParams(
+			Param{Name: "sim", Default: "accounts", Usage: "simulation to run, given the FLAGs"},
+			Param{Name: "strategies", Default: "unsynchronized,mutex,monitor", Usage: "comma separated strategies to run the simulation with, one at a time, if it has a -strategy flag"},
+			Param{Name: "src", Default: mainDir, Usage: "directory of main.go, built with -race unless smt already was"},
+		),
+		Run: func(ctx context.Context, w io.Writer, args Args) error {
+			sim := args.String("sim")
+			d, ok := demos[sim]
+			if !ok || d.Category != "sim" || sim == "race-detector" {
+				return usagef("unknown simulation %q", sim)
+			}
+			var strategies []string
+			for _, p := range d.Params {
+				if p.Name == "strategy" {
+					strategies = strings.Split(args.String("strategies"), ",")
+				}
+			}
+			for _, s := range strategies {
+				if err := checkStrategy(s); err != nil {
+					return usageError{err.Error()}
+				}
+			}
+			format, err := formatArgs(args)
+			if err != nil {
+				return err
+			}
+			return RaceDetector(ctx, w, format, args.String("src"), sim, strategies, args.Rest())
+		},
+	})
+}
+
+const raceDetectorInfo = `
+ RACE DETECTOR REPORT
+ ____________________
+
++-{ Context }-----------------------------------------------------------------------------------------------+
+|                                                                                                           |
+| Go ships a dynamic race detector: built with -race, a program records every access to memory together     |
+| with the synchronization events ordering them, and reports two accesses to the same variable, at least    |
+| one of them a write, that no synchronization orders. It only finds the races that actually run, but it    |
+| finds them even when they did not change the outcome, which is why it is worth running over the           |
+| simulations above rather than waiting for a wrong balance.                                                |
+|                                                                                                           |
++-{ Runs }--------------------------------------------------------------------------------------------------+
+|                                                                                                           |
+%s|                                                                                                           |
++-{ Races }-------------------------------------------------------------------------------------------------+
+|                                                                                                           |
+%s|                                                                                                           |
++-----------------------------------------------------------------------------------------------------------+
+`
+
+// raceEnabled reports whether this program was built with -race, see
+// racedetect_race.go.
+var raceEnabled bool
+
+// mainDir is the directory of the main.go this program was built from,
+// the current directory if unknown.
+var mainDir = func() string {
+	_, file, _, ok := runtime.Caller(0)
+	if !ok || !filepath.IsAbs(file) {
+		return "."
+	}
+	return filepath.Dir(filepath.Dir(file))
+}()
+
+// raceExitCode is the exit code of a program built with -race that
+// found races, see the GORACE environment variable.
+const raceExitCode = 66
+
+// dataRace is a race reported by the race detector: the access that
+// found it and the previous access it conflicts with.
+type dataRace struct {
+	access, previous raceAccess
+}
+
+// raceAccess is a memory access involved in a race.
+type raceAccess struct {
+	kind      string // e.g. "read", "write", "atomic read"
+	goroutine string // e.g. "goroutine 7"
+	at        string // function and source line of the access
+	createdAt string // function and source line starting the goroutine
+}
+
+func (r dataRace) String() string {
+	return r.access.String() + ", races with the previous " + r.previous.String()
+}
+
+func (a raceAccess) String() string {
+	s := a.kind + " in " + a.at
+	if a.createdAt != "" {
+		s += ", goroutine started in " + a.createdAt
+	}
+	return s
+}
+
+// raceRun is a run of a simulation built with -race.
+type raceRun struct {
+	strategy string
+	args     []string
+	races    []dataRace
+}
+
+// RaceDetector runs simulation sim, given flags, with the race detector
+// and summarizes the races it reports. The simulation is run once with
+// every one of strategies, or once if there are none. Unless this program
+// was built with -race, the main.go found in src is built with it.
+func RaceDetector(ctx context.Context, w io.Writer, format Format, src, sim string, strategies, flags []string) error {
+	bin, cleanup, err := raceBinary(ctx, src)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	if len(strategies) == 0 {
+		strategies = []string{""}
+	}
+	var runs []raceRun
+	for _, strategy := range strategies {
+		args := append([]string{sim}, flags...)
+		if format == JSON {
+			args = append(args, "-format", format.String())
+		}
+		if strategy != "" {
+			args = append(args, "-strategy", strategy)
+		}
+		if format == Text {
+			fmt.Fprintf(w, "$ smt %s\n", strings.Join(args, " "))
+		}
+		var stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, bin, args...)
+		cmd.Stdout, cmd.Stderr = w, &stderr
+		cmd.Env = append(os.Environ(), fmt.Sprintf("GORACE=exitcode=%d", raceExitCode))
+		err := cmd.Run()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		races, rest := parseRaces(&stderr)
+		var exit *exec.ExitError
+		if err != nil && !(errors.As(err, &exit) && exit.ExitCode() == raceExitCode) {
+			return fmt.Errorf("smt %s: %v\n%s", strings.Join(args, " "), err, rest)
+		}
+		runs = append(runs, raceRun{strategy, args, races})
+	}
+
+	if format == JSON {
+		var results []SimulationResult
+		for _, run := range runs {
+			var races []string
+			for _, r := range run.races {
+				races = append(races, r.String())
+			}
+			r := newResult("race-detector", run.strategy, map[string]interface{}{"sim": sim, "flags": flags})
+			r.Observed, r.Attempts = len(run.races), 1
+			if run.strategy != "" {
+				r.Expected = expectedRaces(run.strategy)
+			}
+			r.Details = map[string]interface{}{"races": races}
+			results = append(results, r)
+		}
+		return writeResults(w, results...)
+	}
+	var summary, details strings.Builder
+	for _, run := range runs {
+		verdict := "no race"
+		if len(run.races) > 0 {
+			verdict = fmt.Sprintf("%d race(s)", len(run.races))
+		}
+		if run.strategy != "" {
+			if (len(run.races) > 0) == (expectedRaces(run.strategy) > 0) {
+				verdict += ", as expected"
+			} else {
+				verdict += ", NOT expected"
+			}
+		}
+		raceRows(&summary, fmt.Sprintf("smt %-60s %s", strings.Join(run.args, " "), verdict))
+	}
+	n := 0
+	for _, run := range runs {
+		for _, r := range distinctRaces(run.races) {
+			n++
+			raceRows(&details, fmt.Sprintf("%d) smt %s, reported %d time(s):", n, strings.Join(run.args, " "), r.times))
+			raceRows(&details, "   "+r.access.String())
+			raceRows(&details, "   races with the previous "+r.previous.String())
+		}
+	}
+	if n == 0 {
+		fmt.Fprintf(&details, "| %-105s |\n", "None.")
+	}
+	fmt.Fprintf(w, raceDetectorInfo, summary.String(), details.String())
+	return nil
+}
+
+// raceRows writes s as a row of the report, or as several if it is too
+// long for one, the rows after the first indented. A word too long for a
+// row is cut short.
+func raceRows(b *strings.Builder, s string) {
+	if len(s) <= 105 {
+		fmt.Fprintf(b, "| %-105s |\n", s)
+		return
+	}
+	lead := s[:len(s)-len(strings.TrimLeft(s, " "))]
+	for i, line := range wrap(s, 105-len(lead)-3) {
+		if i > 0 {
+			line = "   " + line
+		}
+		line = lead + line
+		if len(line) > 105 {
+			line = line[:102] + "..."
+		}
+		fmt.Fprintf(b, "| %-105s |\n", line)
+	}
+}
+
+// expectedRaces returns the number of races an account of strategy should
+// have, 1 standing for some.
+func expectedRaces(strategy string) int {
+	if strategy == "unsynchronized" {
+		return 1
+	}
+	return 0
+}
+
+// raceBinary returns the path of a smt program built with -race from the
+// main.go in src, the way the Makefile builds it, and a function removing
+// it once done.
+func raceBinary(ctx context.Context, src string) (string, func(), error) {
+	if raceEnabled {
+		bin, err := os.Executable()
+		return bin, func() {}, err
+	}
+	dir, err := ioutil.TempDir("", "smt-race")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+	bin := filepath.Join(dir, "smt")
+	// building the directory would take time.c in as well
+	cmd := exec.CommandContext(ctx, "go", "build", "-race", "-o", bin, "main.go")
+	cmd.Dir = src
+	if out, err := cmd.CombinedOutput(); err != nil {
+		cleanup()
+		if ctx.Err() != nil {
+			return "", nil, ctx.Err()
+		}
+		return "", nil, fmt.Errorf("building %s with -race, see -src: %v\n%s", src, err, out)
+	}
+	return bin, cleanup, nil
+}
+
+var (
+	raceAccessLine  = regexp.MustCompile(`^(Previous )?([A-Za-z ]+) at 0x[0-9a-f]+ by (goroutine \d+|main goroutine):$`)
+	raceCreatedLine = regexp.MustCompile(`^Goroutine (\d+) \(.*\) created at:$`)
+	raceFoundLine   = regexp.MustCompile(`^Found \d+ data race\(s\)$`)
+)
+
+// parseRaces returns the races reported in the output of a program built
+// with -race, and the rest of the output.
+func parseRaces(r io.Reader) ([]dataRace, string) {
+	var races []dataRace
+	var rest strings.Builder
+	var report []string
+	inRace := false
+	input := bufio.NewScanner(r)
+	for input.Scan() {
+		line := input.Text()
+		switch {
+		case line == "WARNING: DATA RACE":
+			inRace, report = true, nil
+		case line == "==================":
+			if inRace {
+				races = append(races, parseRace(report))
+			}
+			inRace = false
+		case inRace:
+			report = append(report, line)
+		case !raceFoundLine.MatchString(line):
+			fmt.Fprintln(&rest, line)
+		}
+	}
+	return races, rest.String()
+}
+
+// parseRace parses the report of a race, made of sections separated by
+// blank lines: a line telling what the section is about, followed by a
+// stack trace, two lines per frame.
+func parseRace(lines []string) dataRace {
+	var r dataRace
+	createdAt := make(map[string]string)
+	for len(lines) > 0 {
+		header, top := lines[0], ""
+		if len(lines) >= 3 {
+			top = shortFunc(strings.TrimSpace(lines[1])) + " (" + sourceLine(lines[2]) + ")"
+		}
+		i := 1
+		for i < len(lines) && lines[i] != "" {
+			i++
+		}
+		for i < len(lines) && lines[i] == "" {
+			i++
+		}
+		lines = lines[i:]
+
+		if m := raceAccessLine.FindStringSubmatch(header); m != nil {
+			a := raceAccess{kind: strings.ToLower(m[2]), goroutine: m[3], at: top}
+			if m[1] == "" {
+				r.access = a
+			} else {
+				r.previous = a
+			}
+		} else if m := raceCreatedLine.FindStringSubmatch(header); m != nil {
+			createdAt["goroutine "+m[1]] = top
+		}
+	}
+	r.access.createdAt = createdAt[r.access.goroutine]
+	r.previous.createdAt = createdAt[r.previous.goroutine]
+	return r
+}
+
+// shortFunc returns the name of function fn without its package path
+// nor its arguments, e.g. "(*racyAccount).Deposit".
+func shortFunc(fn string) string {
+	fn = strings.TrimSuffix(fn, "()")
+	if i := strings.LastIndex(fn, "/"); i >= 0 {
+		fn = fn[i+1:]
+	}
+	if i := strings.Index(fn, "."); i >= 0 {
+		fn = fn[i+1:]
+	}
+	return fn
+}
+
+// sourceLine returns the file base name and line of a stack frame line,
+// e.g. "account.go:173".
+func sourceLine(line string) string {
+	f := strings.Fields(line)
+	if len(f) == 0 {
+		return ""
+	}
+	return filepath.Base(f[0])
+}
+
+// distinctRace is a race reported times times.
+type distinctRace struct {
+	dataRace
+	times int
+}
+
+// distinctRaces returns the races of a run grouped by the code involved,
+// regardless of the goroutines.
+func distinctRaces(races []dataRace) []distinctRace {
+	var distinct []distinctRace
+	seen := make(map[string]int)
+	for _, r := range races {
+		key := r.String()
+		if i, ok := seen[key]; ok {
+			distinct[i].times++
+			continue
+		}
+		seen[key] = len(distinct)
+		distinct = append(distinct, distinctRace{r, 1})
+	}
+	return distinct
+}
@@ -0,0 +1,8 @@
+//go:build race
+// +build race
+
+package smt
+
+func init() {
+	raceEnabled = true
+}
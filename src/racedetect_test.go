@@ -0,0 +1,126 @@
+package smt
+
+import (
+	"strings"
+	"testing"
+)
+
+// raceReport is the output of smt sim financial-lack built with -race,
+// the stacks of the goroutine creations cut short.
+const raceReport = `==================
+WARNING: DATA RACE
+Read at 0x00c000018d28 by goroutine 12:
+  github.com/moll-y/smt/src.(*racyAccount).Deposit()
+      /tmp/smtbuild/src/account.go:183 +0x30
+  github.com/moll-y/smt/src.deposit.func1()
+      /tmp/smtbuild/src/race.go:663 +0x4c
+  github.com/moll-y/smt/src.deposit.gowrap1()
+      /tmp/smtbuild/src/race.go:665 +0x38
+
+Previous write at 0x00c000018d28 by goroutine 13:
+  github.com/moll-y/smt/src.(*racyAccount).Deposit()
+      /tmp/smtbuild/src/account.go:183 +0x47
+  github.com/moll-y/smt/src.deposit.func1()
+      /tmp/smtbuild/src/race.go:663 +0x4c
+  github.com/moll-y/smt/src.deposit.gowrap1()
+      /tmp/smtbuild/src/race.go:665 +0x38
+
+Goroutine 12 (running) created at:
+  github.com/moll-y/smt/src.deposit()
+      /tmp/smtbuild/src/race.go:662 +0x8b
+  github.com/moll-y/smt/src.financialLackRaceConditionSimulation()
+      /tmp/smtbuild/src/race.go:690 +0x10e
+  main.main()
+      /tmp/smtbuild/main.go:6 +0x1c
+
+Goroutine 13 (finished) created at:
+  github.com/moll-y/smt/src.deposit()
+      /tmp/smtbuild/src/race.go:662 +0x8b
+  github.com/moll-y/smt/src.financialLackRaceConditionSimulation()
+      /tmp/smtbuild/src/race.go:690 +0x10e
+  main.main()
+      /tmp/smtbuild/main.go:6 +0x1c
+==================
+`
+
+// atomicRaceReport is a race between an atomic write of the main
+// goroutine, which was not created, and a plain read.
+const atomicRaceReport = `==================
+WARNING: DATA RACE
+Atomic write at 0x00c0000b4010 by main goroutine:
+  sync/atomic.AddInt64()
+      /usr/local/go/src/runtime/race_amd64.s:289 +0xb
+  github.com/moll-y/smt/src.(*atomicAccount).Deposit()
+      /tmp/smtbuild/src/account.go:259 +0x44
+
+Previous read at 0x00c0000b4010 by goroutine 8:
+  github.com/moll-y/smt/src.(*racyAccount).Balance()
+      /tmp/smtbuild/src/account.go:195 +0x2e
+
+Goroutine 8 (running) created at:
+  github.com/moll-y/smt/src.useAccount()
+      /tmp/smtbuild/src/bench.go:193 +0x9c
+==================
+`
+
+func TestParseRaces(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		output string
+		races  []dataRace
+		rest   string
+	}{
+		{
+			name:   "none",
+			output: "150\ngoroutines: 4 before, 4 after, 0 leaked\n",
+			rest:   "150\ngoroutines: 4 before, 4 after, 0 leaked\n",
+		},
+		{
+			name:   "deposits",
+			output: "smt output\n" + raceReport + "Found 1 data race(s)\n",
+			races: []dataRace{{
+				access: raceAccess{
+					kind:      "read",
+					goroutine: "goroutine 12",
+					at:        "(*racyAccount).Deposit (account.go:183)",
+					createdAt: "deposit (race.go:662)",
+				},
+				previous: raceAccess{
+					kind:      "write",
+					goroutine: "goroutine 13",
+					at:        "(*racyAccount).Deposit (account.go:183)",
+					createdAt: "deposit (race.go:662)",
+				},
+			}},
+			rest: "smt output\n",
+		},
+		{
+			name:   "atomic by main",
+			output: raceReport + atomicRaceReport + "Found 2 data race(s)\n",
+			races: []dataRace{
+				{
+					access:   raceAccess{"read", "goroutine 12", "(*racyAccount).Deposit (account.go:183)", "deposit (race.go:662)"},
+					previous: raceAccess{"write", "goroutine 13", "(*racyAccount).Deposit (account.go:183)", "deposit (race.go:662)"},
+				},
+				{
+					access:   raceAccess{"atomic write", "main goroutine", "AddInt64 (race_amd64.s:289)", ""},
+					previous: raceAccess{"read", "goroutine 8", "(*racyAccount).Balance (account.go:195)", "useAccount (bench.go:193)"},
+				},
+			},
+		},
+	} {
+		races, rest := parseRaces(strings.NewReader(test.output))
+		if len(races) != len(test.races) {
+			t.Errorf("%s: got %d races, want %d: %v", test.name, len(races), len(test.races), races)
+			continue
+		}
+		for i := range races {
+			if races[i] != test.races[i] {
+				t.Errorf("%s: race %d\ngot  %+v\nwant %+v", test.name, i, races[i], test.races[i])
+			}
+		}
+		if rest != test.rest {
+			t.Errorf("%s: rest %q, want %q", test.name, rest, test.rest)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package smt
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	Register(Demo{
+		Name:        "readers-writers",
+		Category:    "sim",
+		Description: "read the balance of an account a lot and deposit into it a little, measuring how long depositors wait",
+		Params: simParams(
+			Param{Name: "lock", Default: "all", Usage: "lock to run: all or one of " + strings.Join(readWriteLockNames(), ", ")},
+			Param{Name: "readers", Default: 16, Usage: "number of goroutines reading the balance"},
+			Param{Name: "writers", Default: 2, Usage: "number of goroutines depositing"},
+			Param{Name: "read", Default: 200 * time.Microsecond, Usage: "time a reader holds the lock to read the balance"},
+			Param{Name: "pause", Default: time.Millisecond, Usage: "time a writer waits between two deposits"},
+			Param{Name: "duration", Default: time.Second, Usage: "time every lock is used for"},
+		),
+		Run: func(ctx context.Context, w io.Writer, args Args) error {
+			locks := readWriteLockNames()
+			if l := args.String("lock"); l != "all" {
+				if _, err := newReadWriteLock(l); err != nil {
+					return usageError{err.Error()}
+				}
+				locks = []string{l}
+			}
+			readers, writers := args.Int("readers"), args.Int("writers")
+			if readers < 0 || writers <= 0 {
+				return usagef("-readers must not be negative and -writers must be positive, got %d and %d", readers, writers)
+			}
+			read, pause, duration := args.Duration("read"), args.Duration("pause"), args.Duration("duration")
+			if read < 0 || pause < 0 || duration <= 0 {
+				return usagef("-read and -pause must not be negative, -duration must be positive")
+			}
+			format, err := formatArgs(args)
+			if err != nil {
+				return err
+			}
+			return ReadersWriters(ctx, w, format, locks, readers, writers, read, pause, duration)
+		},
+	})
+}
+
+const readersWritersInfo = `
+ READERS-WRITERS SIMULATION
+ __________________________
+
++-{ Context }-----------------------------------------------------------------------------------------------+
+|                                                                                                           |
+| Most of the time a bank account is read, not written: many goroutines ask for its balance, a few deposit  |
+| into it. A mutex lets a single reader in at a time, although readers could share the account since none   |
+| of them changes it. Sharing it between readers raises a question though: when does a writer get in?       |
+|                                                                                                           |
+|   mutex               readers and writers take turns, one at a time.                                      |
+|   reader-preferring   a reader gets in whenever another reader is in: the first reader in locks the       |
+|                       account for all of them, the last one out unlocks it. Writers wait for no reader    |
+|                       to be left, which may never happen: they starve.                                    |
+|   rwmutex             sync.RWMutex, once a writer calls Lock, new readers wait behind it: the writer      |
+|                       waits for the readers already in, no more.                                          |
+|                                                                                                           |
+%s|                                                                                                           |
++-{ Outcomes }----------------------------------------------------------------------------------------------+
+|                                                                                                           |
+%s|                                                                                                           |
+| wait is the time a deposit took, mostly waiting for the lock: half of the deposits took less than p50,    |
+| all of them less than max. Deposits still waiting when the time is up complete once the readers stop.     |
+|                                                                                                           |
+| With the reader-preferring lock, the deposits are few and the waits as long as the simulation itself.     |
+| With sync.RWMutex, a deposit waits about as long as a read holding the lock, while the readers still      |
+| share the account. The plain mutex serializes the readers, so the deposits queue up behind them.          |
+|                                                                                                           |
++-----------------------------------------------------------------------------------------------------------+
+`
+
+// readWriteLock is a lock that readers may share, unlike writers.
+type readWriteLock interface {
+	RLock()
+	RUnlock()
+	Lock()
+	Unlock()
+}
+
+// readWriteLocks maps the name of every lock to its constructor.
+var readWriteLocks = []struct {
+	name string
+	new  func() readWriteLock
+}{
+	{"mutex", func() readWriteLock { return new(exclusiveLock) }},
+	{"reader-preferring", func() readWriteLock { return newReaderPreferringLock() }},
+	{"rwmutex", func() readWriteLock { return new(sync.RWMutex) }},
+}
+
+func readWriteLockNames() []string {
+	var names []string
+	for _, l := range readWriteLocks {
+		names = append(names, l.name)
+	}
+	return names
+}
+
+func newReadWriteLock(name string) (readWriteLock, error) {
+	for _, l := range readWriteLocks {
+		if l.name == name {
+			return l.new(), nil
+		}
+	}
+	return nil, fmt.Errorf("unknown lock %q, want one of %s", name, strings.Join(readWriteLockNames(), ", "))
+}
+
+// exclusiveLock is a mutex whose readers do not share it.
+type exclusiveLock struct {
+	sync.Mutex
+}
+
+func (l *exclusiveLock) RLock()   { l.Lock() }
+func (l *exclusiveLock) RUnlock() { l.Unlock() }
+
+// readerPreferringLock lets readers in as long as another reader holds
+// it: the first reader in takes the lock for all of them and the last one
+// out gives it back, so a writer waits until no reader at all is left.
+type readerPreferringLock struct {
+	mu       sync.Mutex    // guards readers
+	readers  int           // readers holding the lock
+	resource chan struct{} // holds a token while readers or a writer hold the lock
+}
+
+func newReaderPreferringLock() *readerPreferringLock {
+	return &readerPreferringLock{resource: make(chan struct{}, 1)}
+}
+
+func (l *readerPreferringLock) RLock() {
+	l.mu.Lock()
+	l.readers++
+	if l.readers == 1 {
+		l.resource <- struct{}{}
+	}
+	l.mu.Unlock()
+}
+
+func (l *readerPreferringLock) RUnlock() {
+	l.mu.Lock()
+	l.readers--
+	if l.readers == 0 {
+		<-l.resource
+	}
+	l.mu.Unlock()
+}
+
+func (l *readerPreferringLock) Lock()   { l.resource <- struct{}{} }
+func (l *readerPreferringLock) Unlock() { <-l.resource }
+
+// lockedAccount is an account guarded by a readWriteLock, reading its
+// balance taking reading, as reading a statement would.
+type lockedAccount struct {
+	lock    readWriteLock
+	reading time.Duration
+	balance int
+}
+
+func (a *lockedAccount) Deposit(amount int) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.balance += amount
+}
+
+func (a *lockedAccount) Withdraw(amount int) bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.balance < amount {
+		return false
+	}
+	a.balance -= amount
+	return true
+}
+
+func (a *lockedAccount) Balance() int {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	time.Sleep(a.reading)
+	return a.balance
+}
+
+// readersWritersRun is the outcome of using an account guarded by a lock.
+type readersWritersRun struct {
+	lock  string
+	reads int
+	waits []time.Duration // time every deposit took, sorted
+}
+
+// percentile returns the wait p percent of the deposits did not exceed.
+func (r readersWritersRun) percentile(p int) time.Duration {
+	if len(r.waits) == 0 {
+		return 0
+	}
+	i := (len(r.waits)*p+99)/100 - 1
+	if i < 0 {
+		i = 0
+	}
+	return r.waits[i]
+}
+
+// ReadersWriters makes readers goroutines read the balance of an account
+// guarded by every one of locks, holding the lock for read, while writers
+// goroutines deposit into it every pause, for duration. It reports the
+// percentiles of the time the deposits took.
+func ReadersWriters(ctx context.Context, w io.Writer, format Format, locks []string, readers, writers int, read, pause, duration time.Duration) error {
+	check := leakCheck()
+	var runs []readersWritersRun
+	for _, name := range locks {
+		lock, err := newReadWriteLock(name)
+		if err != nil {
+			return err
+		}
+		r := readersWritersRun{lock: name}
+		r.reads, r.waits = readAndWrite(ctx, &lockedAccount{lock: lock, reading: read}, readers, writers, pause, duration)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		runs = append(runs, r)
+	}
+	leaks := check()
+
+	if format == JSON {
+		params := map[string]interface{}{"readers": readers, "writers": writers, "read_ns": read, "pause_ns": pause, "duration_ns": duration}
+		var results []SimulationResult
+		for _, r := range runs {
+			res := newResult("readers-writers", r.lock, params)
+			res.Observed, res.Attempts, res.Elapsed, res.Goroutines = len(r.waits), 1, duration, leaks
+			res.Details = map[string]interface{}{
+				"reads":     r.reads,
+				"deposits":  len(r.waits),
+				"wait_p50":  r.percentile(50),
+				"wait_p90":  r.percentile(90),
+				"wait_p99":  r.percentile(99),
+				"wait_max":  r.percentile(100),
+				"wait_unit": "ns",
+			}
+			results = append(results, res)
+		}
+		return writeResults(w, results...)
+	}
+	var outcome strings.Builder
+	fmt.Fprintf(&outcome, "| %-18s %9s %9s %12s %12s %12s %12s%15s |\n", "lock", "reads", "deposits", "wait p50", "wait p90", "wait p99", "wait max", "")
+	for _, r := range runs {
+		fmt.Fprintf(&outcome, "| %-18s %9d %9d %12v %12v %12v %12v%15s |\n", r.lock, r.reads, len(r.waits),
+			r.percentile(50).Round(time.Microsecond), r.percentile(90).Round(time.Microsecond),
+			r.percentile(99).Round(time.Microsecond), r.percentile(100).Round(time.Microsecond), "")
+	}
+	setup := boxRows(fmt.Sprintf("%d readers held the lock for %v to read the balance, %d writers deposited every %v, for %v per lock.",
+		readers, read, writers, pause, duration))
+	fmt.Fprintf(w, readersWritersInfo, setup, outcome.String())
+	fmt.Fprintln(w, leaks)
+	return nil
+}
+
+// readAndWrite makes readers goroutines read the balance of account and
+// writers goroutines deposit 1 into it every pause, for duration or until
+// ctx is done. It returns the number of reads and the sorted times the
+// deposits took.
+func readAndWrite(ctx context.Context, account Account, readers, writers int, pause, duration time.Duration) (int, []time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+
+	var mu sync.Mutex
+	var reads int
+	var waits []time.Duration
+	var wg sync.WaitGroup
+	wg.Add(readers + writers)
+	for i := 0; i < readers; i++ {
+		go func() {
+			defer wg.Done()
+			n := 0
+			for ctx.Err() == nil {
+				account.Balance()
+				n++
+			}
+			mu.Lock()
+			reads += n
+			mu.Unlock()
+		}()
+	}
+	for i := 0; i < writers; i++ {
+		go func() {
+			defer wg.Done()
+			var mine []time.Duration
+			for ctx.Err() == nil {
+				start := time.Now()
+				account.Deposit(1)
+				mine = append(mine, time.Since(start))
+				time.Sleep(pause)
+			}
+			mu.Lock()
+			waits = append(waits, mine...)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	sort.Slice(waits, func(i, j int) bool { return waits[i] < waits[j] })
+	return reads, waits
+}
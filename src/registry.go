@@ -0,0 +1,132 @@
+package smt
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// Demo is a demonstration runnable from the command line as
+// "smt CATEGORY NAME [flags] [ARGS...]", or "smt NAME" for short.
+// Demos register themselves with Register, usually from an init
+// function, so a new demo only needs a new file.
+type Demo struct {
+	Name        string
+	Category    string
+	Description string
+	Args        string  // positional arguments, e.g. "[PATH...]"; none allowed if empty
+	Params      []Param // flags accepted by the demo
+
+	// Run executes the demo writing its outcome to w. It should return
+	// as soon as possible once ctx is done, leaving no goroutine behind:
+	// the goroutines it leaked are reported on the standard error, unless
+	// Leaky.
+	Run func(ctx context.Context, w io.Writer, args Args) error
+
+	// Leaky tells that the demo leaks goroutines on purpose, e.g. to
+	// show them deadlocked, so they are not reported.
+	Leaky bool
+}
+
+// Param describes a demo flag. The type of the flag is the type of its
+// Default value, which must be an int, a string, a bool or a time.Duration.
+type Param struct {
+	Name    string
+	Default interface{}
+	Usage   string
+}
+
+// Args holds the values of the demo params and its positional arguments.
+type Args struct {
+	values map[string]interface{}
+	rest   []string
+}
+
+// Int returns the value of the int param called name.
+func (a Args) Int(name string) int { return a.values[name].(int) }
+
+// String returns the value of the string param called name.
+func (a Args) String(name string) string { return a.values[name].(string) }
+
+// Bool returns the value of the bool param called name.
+func (a Args) Bool(name string) bool { return a.values[name].(bool) }
+
+// Duration returns the value of the time.Duration param called name.
+func (a Args) Duration(name string) time.Duration { return a.values[name].(time.Duration) }
+
+// Rest returns the positional arguments.
+func (a Args) Rest() []string { return a.rest }
+
+var demos = make(map[string]Demo)
+
+// categories describes the categories of the demos of this package.
+// Demos may use other categories as well.
+var categories = map[string]string{
+	"example": "run a concurrent program",
+	"serve":   "run a TCP server demonstration",
+	"sim":     "run a race condition simulation",
+}
+
+// Register adds d to the demos runnable from the command line. It panics
+// if a demo with the same name is already registered or d is malformed.
+func Register(d Demo) {
+	if d.Name == "" || d.Category == "" || d.Description == "" || d.Run == nil {
+		panic("smt: demo without name, category, description or run function")
+	}
+	if _, dup := demos[d.Name]; dup {
+		panic("smt: demo " + d.Name + " registered twice")
+	}
+	names := make(map[string]bool)
+	for _, p := range d.Params {
+		if p.Name == "" || names[p.Name] {
+			// e.g. a param called format given to simParams
+			panic(fmt.Sprintf("smt: demo %s: param %q unnamed or defined twice", d.Name, p.Name))
+		}
+		names[p.Name] = true
+		switch p.Default.(type) {
+		case int, string, bool, time.Duration:
+		default:
+			panic(fmt.Sprintf("smt: demo %s: param %s has unsupported type %T", d.Name, p.Name, p.Default))
+		}
+	}
+	demos[d.Name] = d
+}
+
+// Demos returns the registered demos sorted by category and name.
+func Demos() []Demo {
+	list := make([]Demo, 0, len(demos))
+	for _, d := range demos {
+		list = append(list, d)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Category != list[j].Category {
+			return list[i].Category < list[j].Category
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// ListDemos writes the registered demos grouped by category.
+func ListDemos(w io.Writer) {
+	list := Demos()
+	width := 0
+	for _, d := range list {
+		if len(d.Name) > width {
+			width = len(d.Name)
+		}
+	}
+	var category string
+	for _, d := range list {
+		if d.Category != category {
+			category = d.Category
+			fmt.Fprintf(w, "%s\n", category)
+		}
+		fmt.Fprintf(w, "    %-*s %s\n", width, d.Name, d.Description)
+		for _, p := range d.Params {
+			fmt.Fprintf(w, "    %-*s   --%s=%v  %s\n", width, "", p.Name, p.Default, p.Usage)
+		}
+	}
+}
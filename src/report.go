@@ -0,0 +1,97 @@
+package smt
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Format is the way a simulation writes its outcome.
+type Format int
+
+const (
+	Text Format = iota // the explanation boxes, meant for people
+	JSON               // a SimulationResult per line, meant for tools
+)
+
+var formats = []string{"text", "json"}
+
+func (f Format) String() string {
+	return formats[f]
+}
+
+// ParseFormat returns the format called s.
+func ParseFormat(s string) (Format, error) {
+	for i, name := range formats {
+		if s == name {
+			return Format(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown format %q, want one of %s", s, strings.Join(formats, ", "))
+}
+
+// SimulationResult is the outcome of a simulation run with a strategy,
+// as written by the JSON format.
+type SimulationResult struct {
+	Simulation string                 `json:"simulation"`
+	Strategy   string                 `json:"strategy"`
+	Parameters map[string]interface{} `json:"parameters"`
+	Expected   interface{}            `json:"expected"`
+	Observed   interface{}            `json:"observed"`
+	Attempts   int                    `json:"attempts"`
+	Elapsed    time.Duration          `json:"elapsed_ns"`
+	GOMAXPROCS int                    `json:"gomaxprocs"`
+	Goroutines GoroutineCount         `json:"goroutines"`
+	Details    map[string]interface{} `json:"details,omitempty"`
+}
+
+// newResult returns the result of running simulation with strategy,
+// the rest of the fields are up to the caller.
+func newResult(simulation, strategy string, params map[string]interface{}) SimulationResult {
+	return SimulationResult{
+		Simulation: simulation,
+		Strategy:   strategy,
+		Parameters: params,
+		GOMAXPROCS: runtime.GOMAXPROCS(0),
+	}
+}
+
+// writeResults writes results as JSON, one per line.
+func writeResults(w io.Writer, results ...SimulationResult) error {
+	enc := json.NewEncoder(w)
+	for _, r := range results {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// boxRows returns s wrapped into rows of an explanation box, for text
+// whose length is only known once formatted.
+func boxRows(s string) string {
+	var b strings.Builder
+	for _, line := range wrap(s, 105) {
+		fmt.Fprintf(&b, "| %-105s |\n", line)
+	}
+	return b.String()
+}
+
+// simParams returns params followed by the param choosing the format
+// of a simulation.
+func simParams(params ...Param) []Param {
+	return append(params[:len(params):len(params)],
+		Param{Name: "format", Default: "text", Usage: "output format: text or json"})
+}
+
+// formatArgs returns the format given by the args of simParams.
+func formatArgs(args Args) (Format, error) {
+	f, err := ParseFormat(args.String("format"))
+	if err != nil {
+		return f, usageError{err.Error()}
+	}
+	return f, nil
+}
@@ -0,0 +1,204 @@
+package smt
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+)
+
+func init() {
+	Register(Demo{
+		Name:        "replay",
+		Category:    "sim",
+		Description: "schedule concurrent deposits at random from a seed and replay them step by step",
+		Params: simParams(
+			Param{Name: "amounts", Default: "100,50", Usage: "comma separated amounts deposited, one per depositor"},
+			Param{Name: "seed", Default: 0, Usage: "seed of the scheduler, 0 picks one"},
+			Param{Name: "pause", Default: false, Usage: "wait for Enter before every step"},
+		),
+		Run: func(ctx context.Context, w io.Writer, args Args) error {
+			amounts, err := amountsArgs(args)
+			if err != nil {
+				return err
+			}
+			format, err := formatArgs(args)
+			if err != nil {
+				return err
+			}
+			seed := int64(args.Int("seed"))
+			if seed == 0 {
+				seed = time.Now().UnixNano()
+			}
+			var pause io.Reader
+			if args.Bool("pause") {
+				pause = os.Stdin
+			}
+			return Replay(ctx, w, format, amounts, seed, pause)
+		},
+	})
+}
+
+const replayInfo = `
+ SCHEDULE REPLAY
+ _______________
+
++-{ Context }-----------------------------------------------------------------------------------------------+
+|                                                                                                           |
+| A deposit is a read of the balance into a register of the goroutine, then a write of the register plus    |
+| the amount back into the balance. Below, a scheduler picked at random which depositor takes the next      |
+| step, and the balance and every register are shown after each step. The same seed always picks the same   |
+| schedule, so a schedule losing a deposit can be replayed and paused on, step by step.                     |
+|                                                                                                           |
+%s|                                                                                                           |
+%s|                                                                                                           |
++-{ Steps }-------------------------------------------------------------------------------------------------+
+|                                                                                                           |
+%s|                                                                                                           |
++-----------------------------------------------------------------------------------------------------------+
+`
+
+// randomSchedule returns the schedule of the steps of n depositors picked
+// by a random scheduler seeded with seed.
+func randomSchedule(n int, seed int64) schedule {
+	r := rand.New(rand.NewSource(seed))
+	left := make([]int, n)
+	for i := range left {
+		left[i] = steps
+	}
+	var s schedule
+	for len(s) < n*steps {
+		i := r.Intn(n)
+		if left[i] == 0 {
+			continue
+		}
+		left[i]--
+		s = append(s, i)
+	}
+	return s
+}
+
+// findSeed returns the first of up to tries seeds whose random schedule
+// of the deposits of amounts ends with balance.
+func findSeed(amounts []int, balance int, tries int) (int64, bool) {
+	for seed := int64(1); seed <= int64(tries); seed++ {
+		if b, _ := randomSchedule(len(amounts), seed).run(amounts); b.balance == balance {
+			return seed, true
+		}
+	}
+	return 0, false
+}
+
+// Replay runs the deposits of amounts as scheduled by a random scheduler
+// seeded with seed, showing the balance and the registers of the
+// depositors after every step. If pause is not nil, every step is also
+// written as soon as it is taken, after reading a line from pause.
+func Replay(ctx context.Context, w io.Writer, format Format, amounts []int, seed int64, pause io.Reader) error {
+	var want int
+	for _, amount := range amounts {
+		want += amount
+	}
+	var legend strings.Builder
+	writeLegend(&legend, amounts)
+
+	header := fmt.Sprintf("%4s  %-8s %8s", "step", "action", "balance")
+	for i := range amounts {
+		header += fmt.Sprintf(" %8s", depositorNames[i][:1]+".reg")
+	}
+	var table strings.Builder
+	fmt.Fprintf(&table, "| %-105s |\n", header)
+
+	var lines *bufio.Scanner
+	if pause != nil && format == Text {
+		lines = bufio.NewScanner(pause)
+		fmt.Fprintf(w, "seed %d\n%s\n", seed, header)
+	}
+
+	b := newBank(amounts)
+	var replayed []map[string]interface{}
+	for n, i := range randomSchedule(len(amounts), seed) {
+		if lines != nil {
+			fmt.Fprintf(w, "press Enter for step %d ", n+1)
+			if err := waitLine(ctx, lines); err != nil {
+				return err
+			}
+		}
+		before := b.deposits
+		action := b.step(i)
+		row := fmt.Sprintf("%4d  %-8s %8d", n+1, action, b.balance)
+		for j := range amounts {
+			if b.pcs[j] == 0 {
+				row += fmt.Sprintf(" %8s", "-")
+			} else {
+				row += fmt.Sprintf(" %8d", b.registers[j])
+			}
+		}
+		if overwritten := before &^ b.deposits; overwritten != 0 {
+			row += "  <-- overwrites the deposit of " + depositorsIn(overwritten)
+		}
+		if len(row) <= 105 {
+			fmt.Fprintf(&table, "| %-105s |\n", row)
+		} else {
+			// the note does not fit beside the registers of five
+			// depositors, right-align it on a row of its own
+			at := strings.Index(row, "  <-- ")
+			fmt.Fprintf(&table, "| %-105s |\n", row[:at])
+			fmt.Fprintf(&table, "| %105s |\n", row[at+2:])
+		}
+		if lines != nil {
+			fmt.Fprintln(w, row)
+		}
+		replayed = append(replayed, map[string]interface{}{
+			"action":    action,
+			"balance":   b.balance,
+			"registers": append([]int(nil), b.registers...),
+		})
+	}
+
+	if format == JSON {
+		r := newResult("replay", "unsynchronized", map[string]interface{}{"amounts": amounts, "seed": seed})
+		r.Expected, r.Observed, r.Attempts = want, b.balance, 1
+		r.Details = map[string]interface{}{"steps": replayed, "lost": b.lost()}
+		return writeResults(w, r)
+	}
+	outcome := boxRows(fmt.Sprintf("Seed %d. The expected balance is %d, the final balance is %d.", seed, want, b.balance))
+	fmt.Fprintf(w, replayInfo, legend.String(), outcome, table.String())
+	return nil
+}
+
+// writeLegend writes the box rows telling who deposits every amount.
+func writeLegend(w io.Writer, amounts []int) {
+	for i, amount := range amounts {
+		fmt.Fprintf(w, "| %-105s |\n", fmt.Sprintf("%s = %s, depositing %d", depositorNames[i][:1], depositorNames[i], amount))
+	}
+}
+
+// depositorsIn returns the names of the depositors in the deposits set.
+func depositorsIn(deposits uint) string {
+	var names []string
+	for i, name := range depositorNames {
+		if deposits&(1<<uint(i)) != 0 {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// waitLine reads a line from lines, unless ctx is done first.
+func waitLine(ctx context.Context, lines *bufio.Scanner) error {
+	read := make(chan error, 1)
+	go func() {
+		lines.Scan()
+		read <- lines.Err()
+	}()
+	select {
+	case err := <-read:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,302 @@
+package smt
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Handler serves a single connection. It is expected to return soon
+// after ctx is done, which happens when the server shuts down.
+type Handler func(ctx context.Context, c net.Conn)
+
+// ServerMode is the way a server handles its connections.
+type ServerMode int
+
+const (
+	Sequential ServerMode = iota // one connection at a time
+	Concurrent                   // every connection in its own goroutine
+	Pool                         // a fixed pool of goroutines fed from a queue
+)
+
+var serverModes = []string{"sequential", "concurrent", "pool"}
+
+func (m ServerMode) String() string {
+	return serverModes[m]
+}
+
+// ParseServerMode returns the mode called s.
+func ParseServerMode(s string) (ServerMode, error) {
+	for i, name := range serverModes {
+		if s == name {
+			return ServerMode(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown server mode %q, want one of %s", s, strings.Join(serverModes, ", "))
+}
+
+// QueuePolicy is what a Pool server does with a new connection when
+// its accept queue is full.
+type QueuePolicy int
+
+const (
+	Block  QueuePolicy = iota // stop accepting until the queue has room
+	Reject                    // tell the client the server is busy and close
+	Drop                      // close the connection without a word
+)
+
+var queuePolicies = []string{"block", "reject", "drop"}
+
+func (p QueuePolicy) String() string {
+	return queuePolicies[p]
+}
+
+// ParseQueuePolicy returns the policy called s.
+func ParseQueuePolicy(s string) (QueuePolicy, error) {
+	for i, name := range queuePolicies {
+		if s == name {
+			return QueuePolicy(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown queue policy %q, want one of %s", s, strings.Join(queuePolicies, ", "))
+}
+
+// ServerConfig configures a server made by MakeServer.
+type ServerConfig struct {
+	Addr Address
+	Mode ServerMode
+
+	// Listening, if not nil, is called with the address the server
+	// listens on, e.g. to learn the port chosen for port "0".
+	Listening func(net.Addr)
+
+	// Workers is the number of handler goroutines of a Pool server,
+	// QueueDepth the number of accepted connections that may wait for
+	// one of them and QueuePolicy what to do once they are that many.
+	Workers     int
+	QueueDepth  int
+	QueuePolicy QueuePolicy
+
+	// DrainTimeout is how long the server waits for the connections
+	// in flight after it stops accepting new ones. The connections
+	// still open after it are closed by force.
+	DrainTimeout time.Duration
+}
+
+// ServerReport sums up the life of a server made by MakeServer.
+type ServerReport struct {
+	Served      int // connections handed to the handler
+	Rejected    int // connections told the server is busy, Pool only
+	Dropped     int // connections closed without a word, Pool only
+	ForceClosed int // connections closed once DrainTimeout expired
+}
+
+// MakeServer make easy to create this package's servers, e.g, ClockServer
+// or EchoServer, handling connections as cfg.Mode says. The server stops
+// accepting connections once ctx is done, then it waits for the in-flight
+// handlers, whose context is done as well, for at most cfg.DrainTimeout
+// before closing their connections by force.
+func MakeServer(ctx context.Context, fn Handler, cfg ServerConfig) (ServerReport, error) {
+	if cfg.Mode == Pool && cfg.Workers <= 0 {
+		return ServerReport{}, fmt.Errorf("pool server needs at least one worker, got %d", cfg.Workers)
+	}
+	listener, err := cfg.Addr.Listen()
+	if err != nil {
+		return ServerReport{}, err
+	}
+	if cfg.Listening != nil {
+		cfg.Listening(listener.Addr())
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-stop:
+		}
+		listener.Close()
+	}()
+
+	conns := newConnSet()
+	var queue chan net.Conn
+	if cfg.Mode == Pool {
+		queue = make(chan net.Conn, cfg.QueueDepth)
+		for i := 0; i < cfg.Workers; i++ {
+			conns.wg.Add(1)
+			go func() {
+				defer conns.wg.Done()
+				for conn := range queue {
+					conns.handle(ctx, fn, conn)
+				}
+			}()
+		}
+	}
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				// shutting down
+				break
+			}
+			// connection aborted
+			log.Print(err)
+			continue
+		}
+		conns.add(conn)
+		switch cfg.Mode {
+		case Sequential:
+			select {
+			case <-conns.serve(ctx, fn, conn):
+			case <-ctx.Done():
+			}
+		case Concurrent:
+			conns.serve(ctx, fn, conn)
+		case Pool:
+			conns.enqueue(ctx, queue, conn, cfg.QueuePolicy)
+		}
+	}
+	if queue != nil {
+		// the workers hand what is left in the queue to the handler,
+		// which returns at once since ctx is done.
+		close(queue)
+	}
+	return conns.drain(cfg.DrainTimeout), nil
+}
+
+// connSet keeps track of the connections being handled by a server.
+type connSet struct {
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+	wg    sync.WaitGroup // handler goroutines, pool workers and rejections
+
+	served, rejected, dropped int
+}
+
+func newConnSet() *connSet {
+	return &connSet{conns: make(map[net.Conn]struct{})}
+}
+
+// add starts tracking conn, which must be then handled or discarded.
+func (s *connSet) add(conn net.Conn) {
+	s.mu.Lock()
+	s.conns[conn] = struct{}{}
+	s.mu.Unlock()
+}
+
+// handle runs fn on conn and discards conn afterwards.
+func (s *connSet) handle(ctx context.Context, fn Handler, conn net.Conn) {
+	s.mu.Lock()
+	s.served++
+	s.mu.Unlock()
+	fn(ctx, conn)
+	s.discard(conn)
+}
+
+// discard closes conn and stops tracking it.
+func (s *connSet) discard(conn net.Conn) {
+	conn.Close()
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+}
+
+// serve handles conn in a new goroutine and returns a channel closed
+// once the handler returns.
+func (s *connSet) serve(ctx context.Context, fn Handler, conn net.Conn) <-chan struct{} {
+	done := make(chan struct{})
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer close(done)
+		s.handle(ctx, fn, conn)
+	}()
+	return done
+}
+
+// enqueue hands conn to the pool workers through queue, applying policy
+// if the queue is full.
+func (s *connSet) enqueue(ctx context.Context, queue chan<- net.Conn, conn net.Conn, policy QueuePolicy) {
+	if policy == Block {
+		select {
+		case queue <- conn:
+		case <-ctx.Done():
+			s.discard(conn)
+		}
+		return
+	}
+
+	select {
+	case queue <- conn:
+		return
+	default:
+	}
+	if policy == Reject {
+		s.reject(conn)
+	} else {
+		s.discard(conn)
+	}
+	s.mu.Lock()
+	if policy == Reject {
+		s.rejected++
+	} else {
+		s.dropped++
+	}
+	s.mu.Unlock()
+}
+
+// rejectLinger is how long a rejected connection stays open for its
+// client to read that the server is busy: closing it with input unread
+// would reset it, and the client could lose the message.
+const rejectLinger = time.Second
+
+// reject tells the client of conn that the server is busy, then it
+// half-closes conn and discards its input for at most rejectLinger before
+// discarding conn, in a new goroutine not to hold up accepting.
+func (s *connSet) reject(conn net.Conn) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer s.discard(conn)
+		io.WriteString(conn, "server busy, try again later\n")
+		if c, ok := conn.(interface{ CloseWrite() error }); ok {
+			c.CloseWrite()
+		}
+		conn.SetReadDeadline(time.Now().Add(rejectLinger))
+		io.Copy(io.Discard, conn)
+	}()
+}
+
+// drain waits for the handlers for at most timeout, then it closes the
+// connections left so their handlers fail and return.
+func (s *connSet) drain(timeout time.Duration) ServerReport {
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	var forced int
+	select {
+	case <-done:
+	case <-timer.C:
+		s.mu.Lock()
+		for conn := range s.conns {
+			conn.Close()
+			forced++
+		}
+		s.mu.Unlock()
+		<-done
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return ServerReport{Served: s.served, Rejected: s.rejected, Dropped: s.dropped, ForceClosed: forced}
+}
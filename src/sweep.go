@@ -0,0 +1,212 @@
+package smt
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	var names []string
+	for name := range sweepTrials {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	Register(Demo{
+		Name:        "sweep",
+		Category:    "sim",
+		Description: "run a race simulation with every GOMAXPROCS, with and without CPU load, and tell how often it races",
+		Params: simParams(
+			Param{Name: "sim", Default: "financial-lack", Usage: "simulation to sweep: " + strings.Join(names, ", ")},
+			Param{Name: "procs", Default: 0, Usage: "highest GOMAXPROCS swept, 0 means the number of CPUs"},
+			Param{Name: "burners", Default: 0, Usage: "goroutines burning the CPU in a second pass, 0 means no second pass"},
+			Param{Name: "trials", Default: 100000, Usage: "most trials per configuration"},
+			Param{Name: "budget", Default: 2 * time.Second, Usage: "most time per configuration"},
+		),
+		Run: func(ctx context.Context, w io.Writer, args Args) error {
+			sim := args.String("sim")
+			if sweepTrials[sim] == nil {
+				return usagef("unknown simulation %q, want one of %s", sim, strings.Join(names, ", "))
+			}
+			procs, burners, trials, budget := args.Int("procs"), args.Int("burners"), args.Int("trials"), args.Duration("budget")
+			if procs < 0 || burners < 0 {
+				return usagef("-procs and -burners must not be negative, got %d and %d", procs, burners)
+			}
+			if trials <= 0 || budget <= 0 {
+				return usagef("-trials and -budget must be positive, got %d and %v", trials, budget)
+			}
+			if procs == 0 {
+				procs = runtime.NumCPU()
+			}
+			format, err := formatArgs(args)
+			if err != nil {
+				return err
+			}
+			return Sweep(ctx, w, format, sim, procs, burners, trials, budget)
+		},
+	})
+}
+
+const sweepInfo = `
+ GOMAXPROCS AND LOAD SWEEP
+ _________________________
+
++-{ Context }-----------------------------------------------------------------------------------------------+
+|                                                                                                           |
+| Race conditions "may remain latent in a program and appear infrequently, perhaps only under heavy load".  |
+| Here the same racy simulation was run again and again with every GOMAXPROCS value, that is with as many   |
+| goroutines really running at once, with and without goroutines burning the CPU in the background.         |
+|                                                                                                           |
+%s|                                                                                                           |
+| With GOMAXPROCS set to 1 the goroutines only take turns, and a turn rarely ends between the read and the  |
+| write of a deposit, or the check and the act of a withdrawal, so the race may never show up. With more,   |
+| they truly overlap.                                                                                       |
+|                                                                                                           |
++-{ Outcomes }----------------------------------------------------------------------------------------------+
+|                                                                                                           |
+%s|                                                                                                           |
++-----------------------------------------------------------------------------------------------------------+
+`
+
+// sweepTrials maps the simulations Sweep can run to a single trial of
+// them, which reports whether it raced.
+var sweepTrials = map[string]func() bool{
+	"financial-lack": func() bool {
+		const alice, bob = 100, 50
+		return deposit(new(racyAccount), alice, bob) != alice+bob
+	},
+	"overdraft": func() bool {
+		// not the yieldingAccount of the overdraft simulation, whose
+		// forced yield would race whatever GOMAXPROCS
+		const balance, withdrawers = 100, 2
+		account := new(racyAccount)
+		account.Deposit(balance)
+		withdraw(account, withdrawers, balance)
+		return account.Balance() < 0
+	},
+}
+
+// sweepOutcome is the outcome of a sweep configuration.
+type sweepOutcome struct {
+	procs, burners int
+	trials, races  int
+	first          int           // trial that raced first, 0 if none
+	firstAfter     time.Duration // time taken by the trials up to first
+	elapsed        time.Duration
+}
+
+// Sweep runs the trials of simulation sim with GOMAXPROCS set to every
+// value from 1 to procs, then again with burners goroutines burning the
+// CPU if burners is positive. Every configuration runs up to trials
+// trials or for up to budget, and reports when the first race happened
+// and how often the trials raced.
+func Sweep(ctx context.Context, w io.Writer, format Format, sim string, procs, burners, trials int, budget time.Duration) error {
+	trial := sweepTrials[sim]
+	if trial == nil {
+		return fmt.Errorf("unknown simulation %q", sim)
+	}
+	check := leakCheck()
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(0))
+	loads := []int{0}
+	if burners > 0 {
+		loads = append(loads, burners)
+	}
+	var outcomes []sweepOutcome
+	for _, load := range loads {
+		for p := 1; p <= procs; p++ {
+			runtime.GOMAXPROCS(p)
+			o := sweep(ctx, trial, load, trials, budget)
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			o.procs = p
+			outcomes = append(outcomes, o)
+		}
+	}
+	leaks := check()
+
+	if format == JSON {
+		params := map[string]interface{}{"trials": trials, "budget_ns": budget}
+		var results []SimulationResult
+		for _, o := range outcomes {
+			r := newResult("sweep", sim, params)
+			r.Expected, r.Observed, r.Attempts, r.Elapsed = 0, o.races, o.trials, o.elapsed
+			r.GOMAXPROCS, r.Goroutines = o.procs, leaks
+			r.Details = map[string]interface{}{
+				"burners":             o.burners,
+				"race_rate":           o.rate(),
+				"first_race":          o.first,
+				"first_race_after_ns": o.firstAfter,
+			}
+			results = append(results, r)
+		}
+		return writeResults(w, results...)
+	}
+	var outcome strings.Builder
+	fmt.Fprintf(&outcome, "| %10s %8s %10s %10s %12s %12s %14s%23s |\n", "GOMAXPROCS", "burners", "trials", "races", "race rate", "first race", "after", "")
+	for _, o := range outcomes {
+		first, after := "-", "-"
+		if o.first > 0 {
+			first, after = fmt.Sprint(o.first), o.firstAfter.Round(time.Microsecond).String()
+		}
+		fmt.Fprintf(&outcome, "| %10d %8d %10d %10d %12.6f %12s %14s%23s |\n", o.procs, o.burners, o.trials, o.races, o.rate(), first, after, "")
+	}
+	setup := boxRows(fmt.Sprintf("Simulation: %s. Up to %d trials or %v per configuration.", sim, trials, budget))
+	fmt.Fprintf(w, sweepInfo, setup, outcome.String())
+	fmt.Fprintln(w, leaks)
+	return nil
+}
+
+// sweep runs trial up to trials times, or for up to budget, while burners
+// goroutines burn the CPU.
+func sweep(ctx context.Context, trial func() bool, burners, trials int, budget time.Duration) sweepOutcome {
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(burners)
+	for i := 0; i < burners; i++ {
+		go func() {
+			defer wg.Done()
+			burn(stop)
+		}()
+	}
+	defer wg.Wait()
+	defer close(stop)
+
+	o := sweepOutcome{burners: burners}
+	start := time.Now()
+	for o.trials < trials && time.Since(start) < budget && ctx.Err() == nil {
+		o.trials++
+		if trial() {
+			o.races++
+			if o.first == 0 {
+				o.first, o.firstAfter = o.trials, time.Since(start)
+			}
+		}
+	}
+	o.elapsed = time.Since(start)
+	return o
+}
+
+// burn keeps a CPU busy until stop is closed.
+func burn(stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+	}
+}
+
+// rate returns the fraction of the trials that raced.
+func (o sweepOutcome) rate() float64 {
+	if o.trials == 0 {
+		return 0
+	}
+	return float64(o.races) / float64(o.trials)
+}
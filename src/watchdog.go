@@ -0,0 +1,41 @@
+package smt
+
+import (
+	"context"
+	"time"
+)
+
+// minPoll is the shortest interval a watchdog polls progress at.
+const minPoll = time.Millisecond
+
+// watchdog returns a channel closed once progress, polled every tenth
+// of stall but no more often than every minPoll, reported the same count
+// for stall, that is once the goroutines it watches stopped making
+// progress, e.g. because they deadlocked. It watches until ctx is done, so
+// the caller should cancel ctx once it is no longer interested.
+func watchdog(ctx context.Context, progress func() int64, stall time.Duration) <-chan struct{} {
+	stalled := make(chan struct{})
+	go func() {
+		poll := stall / 10
+		if poll < minPoll {
+			poll = minPoll
+		}
+		ticker := time.NewTicker(poll)
+		defer ticker.Stop()
+		last, since := progress(), time.Now()
+		for {
+			select {
+			case <-ticker.C:
+				if n := progress(); n != last {
+					last, since = n, time.Now()
+				} else if time.Since(since) >= stall {
+					close(stalled)
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return stalled
+}